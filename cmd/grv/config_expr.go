@@ -0,0 +1,616 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// exprTokenType identifies the kind of token produced while lexing the raw
+// text captured between "$(" and ")" by the config scanner
+type exprTokenType int
+
+const (
+	exprEOF exprTokenType = iota
+	exprNumber
+	exprString
+	exprVariable
+	exprIdent
+	exprLParen
+	exprRParen
+	exprOperator
+)
+
+type exprToken struct {
+	tokenType exprTokenType
+	value     string
+}
+
+// exprLexer tokenises the contents of a $(...) expression
+type exprLexer struct {
+	input []rune
+	pos   int
+}
+
+func newExprLexer(input string) *exprLexer {
+	return &exprLexer{input: []rune(input)}
+}
+
+func (lexer *exprLexer) peek() (rune, bool) {
+	if lexer.pos >= len(lexer.input) {
+		return 0, false
+	}
+	return lexer.input[lexer.pos], true
+}
+
+func (lexer *exprLexer) advance() (rune, bool) {
+	r, ok := lexer.peek()
+	if ok {
+		lexer.pos++
+	}
+	return r, ok
+}
+
+func (lexer *exprLexer) hasPrefix(value string) bool {
+	runes := []rune(value)
+	if lexer.pos+len(runes) > len(lexer.input) {
+		return false
+	}
+	for i, r := range runes {
+		if lexer.input[lexer.pos+i] != r {
+			return false
+		}
+	}
+	return true
+}
+
+func isExprSpace(r rune) bool { return r == ' ' || r == '\t' || r == '\n' }
+func isExprDigit(r rune) bool { return r >= '0' && r <= '9' }
+func isExprIdentStart(r rune) bool {
+	return r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+}
+func isExprIdentChar(r rune) bool { return isExprIdentStart(r) || isExprDigit(r) || r == '-' }
+
+// multiCharOperators must be checked before their single-character prefixes
+var multiCharOperators = []string{"==", "!=", "<=", ">=", "&&", "||"}
+var singleCharOperators = []string{"+", "-", "*", "/", "<", ">", "!"}
+
+// NextToken returns the next token in the expression, or an exprEOF token
+// once the input is exhausted
+func (lexer *exprLexer) NextToken() (exprToken, error) {
+	for {
+		r, ok := lexer.peek()
+		if !ok {
+			return exprToken{tokenType: exprEOF}, nil
+		} else if !isExprSpace(r) {
+			break
+		}
+		lexer.advance()
+	}
+
+	r, _ := lexer.peek()
+
+	switch {
+	case r == '(':
+		lexer.advance()
+		return exprToken{tokenType: exprLParen, value: "("}, nil
+	case r == ')':
+		lexer.advance()
+		return exprToken{tokenType: exprRParen, value: ")"}, nil
+	case r == '$':
+		return lexer.scanVariable()
+	case r == '"':
+		return lexer.scanString()
+	case isExprDigit(r):
+		return lexer.scanNumber(), nil
+	case isExprIdentStart(r):
+		return lexer.scanIdent(), nil
+	default:
+		return lexer.scanOperator()
+	}
+}
+
+func (lexer *exprLexer) scanVariable() (exprToken, error) {
+	lexer.advance()
+
+	var name strings.Builder
+	for {
+		next, ok := lexer.peek()
+		if !ok || !isExprIdentChar(next) {
+			break
+		}
+		lexer.advance()
+		name.WriteRune(next)
+	}
+
+	if name.Len() == 0 {
+		return exprToken{}, fmt.Errorf("Expected variable name after \"$\"")
+	}
+
+	return exprToken{tokenType: exprVariable, value: name.String()}, nil
+}
+
+func (lexer *exprLexer) scanString() (exprToken, error) {
+	lexer.advance()
+
+	var value strings.Builder
+	for {
+		next, ok := lexer.advance()
+		if !ok {
+			return exprToken{}, fmt.Errorf("Unterminated string literal")
+		} else if next == '"' {
+			return exprToken{tokenType: exprString, value: value.String()}, nil
+		}
+		value.WriteRune(next)
+	}
+}
+
+func (lexer *exprLexer) scanNumber() exprToken {
+	var value strings.Builder
+	for {
+		next, ok := lexer.peek()
+		if !ok || !(isExprDigit(next) || next == '.') {
+			break
+		}
+		lexer.advance()
+		value.WriteRune(next)
+	}
+
+	return exprToken{tokenType: exprNumber, value: value.String()}
+}
+
+func (lexer *exprLexer) scanIdent() exprToken {
+	var value strings.Builder
+	for {
+		next, ok := lexer.peek()
+		if !ok || !isExprIdentChar(next) {
+			break
+		}
+		lexer.advance()
+		value.WriteRune(next)
+	}
+
+	return exprToken{tokenType: exprIdent, value: value.String()}
+}
+
+func (lexer *exprLexer) scanOperator() (exprToken, error) {
+	for _, op := range multiCharOperators {
+		if lexer.hasPrefix(op) {
+			lexer.pos += len([]rune(op))
+			return exprToken{tokenType: exprOperator, value: op}, nil
+		}
+	}
+
+	r, _ := lexer.peek()
+	for _, op := range singleCharOperators {
+		if string(r) == op {
+			lexer.advance()
+			return exprToken{tokenType: exprOperator, value: op}, nil
+		}
+	}
+
+	return exprToken{}, fmt.Errorf("Unexpected character \"%c\" in expression", r)
+}
+
+// exprValueKind identifies the runtime type of an evaluated expression value
+type exprValueKind int
+
+const (
+	exprValInt exprValueKind = iota
+	exprValFloat
+	exprValString
+	exprValBool
+)
+
+// exprValue is the typed result of evaluating an expression node
+type exprValue struct {
+	kind      exprValueKind
+	intValue  int64
+	floatVal  float64
+	strValue  string
+	boolValue bool
+}
+
+func (value exprValue) String() string {
+	switch value.kind {
+	case exprValInt:
+		return strconv.FormatInt(value.intValue, 10)
+	case exprValFloat:
+		return strconv.FormatFloat(value.floatVal, 'g', -1, 64)
+	case exprValBool:
+		return strconv.FormatBool(value.boolValue)
+	default:
+		return value.strValue
+	}
+}
+
+func (value exprValue) asFloat() (float64, bool) {
+	switch value.kind {
+	case exprValInt:
+		return float64(value.intValue), true
+	case exprValFloat:
+		return value.floatVal, true
+	default:
+		return 0, false
+	}
+}
+
+func (value exprValue) asBool() (bool, bool) {
+	if value.kind == exprValBool {
+		return value.boolValue, true
+	}
+	return false, false
+}
+
+// exprNode is a node of the AST produced by exprParser
+type exprNode interface {
+	Eval(variables map[string]string) (exprValue, error)
+}
+
+type exprLiteralNode struct {
+	value exprValue
+}
+
+func (node *exprLiteralNode) Eval(variables map[string]string) (exprValue, error) {
+	return node.value, nil
+}
+
+// exprVarNode looks up a previously set config variable by name. Config
+// variables are stored as strings, so a value that looks numeric is
+// evaluated as a number, allowing it to be used in arithmetic and
+// comparison expressions rather than just string equality.
+type exprVarNode struct {
+	name string
+}
+
+func (node *exprVarNode) Eval(variables map[string]string) (exprValue, error) {
+	value, ok := variables[node.name]
+	if !ok {
+		return exprValue{}, fmt.Errorf("Unknown variable \"$%v\"", node.name)
+	}
+
+	if intValue, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return exprValue{kind: exprValInt, intValue: intValue}, nil
+	} else if floatValue, err := strconv.ParseFloat(value, 64); err == nil {
+		return exprValue{kind: exprValFloat, floatVal: floatValue}, nil
+	} else if value == "true" || value == "false" {
+		return exprValue{kind: exprValBool, boolValue: value == "true"}, nil
+	}
+
+	return exprValue{kind: exprValString, strValue: value}, nil
+}
+
+type exprUnaryNode struct {
+	op      string
+	operand exprNode
+}
+
+func (node *exprUnaryNode) Eval(variables map[string]string) (exprValue, error) {
+	operand, err := node.operand.Eval(variables)
+	if err != nil {
+		return exprValue{}, err
+	}
+
+	switch node.op {
+	case "not", "!":
+		boolValue, ok := operand.asBool()
+		if !ok {
+			return exprValue{}, fmt.Errorf("\"not\" requires a boolean operand")
+		}
+		return exprValue{kind: exprValBool, boolValue: !boolValue}, nil
+	case "-":
+		floatValue, ok := operand.asFloat()
+		if !ok {
+			return exprValue{}, fmt.Errorf("Unary \"-\" requires a numeric operand")
+		}
+		if operand.kind == exprValInt {
+			return exprValue{kind: exprValInt, intValue: -operand.intValue}, nil
+		}
+		return exprValue{kind: exprValFloat, floatVal: -floatValue}, nil
+	}
+
+	return exprValue{}, fmt.Errorf("Unknown unary operator \"%v\"", node.op)
+}
+
+// exprBinaryNode evaluates one of the eq/ne/lt/le/gt/ge/add/sub/mul/div/
+// and/or operators. and/or short-circuit: the right operand is only
+// evaluated if the left operand doesn't already decide the result.
+type exprBinaryNode struct {
+	op    string
+	left  exprNode
+	right exprNode
+}
+
+func (node *exprBinaryNode) Eval(variables map[string]string) (exprValue, error) {
+	left, err := node.left.Eval(variables)
+	if err != nil {
+		return exprValue{}, err
+	}
+
+	switch node.op {
+	case "and", "&&":
+		return node.evalShortCircuit(variables, left, false)
+	case "or", "||":
+		return node.evalShortCircuit(variables, left, true)
+	}
+
+	right, err := node.right.Eval(variables)
+	if err != nil {
+		return exprValue{}, err
+	}
+
+	switch node.op {
+	case "eq", "==":
+		return exprValue{kind: exprValBool, boolValue: valuesEqual(left, right)}, nil
+	case "ne", "!=":
+		return exprValue{kind: exprValBool, boolValue: !valuesEqual(left, right)}, nil
+	case "lt", "<", "le", "<=", "gt", ">", "ge", ">=":
+		return compareValues(node.op, left, right)
+	case "add", "+", "sub", "-", "mul", "*", "div", "/":
+		return arithmeticValues(node.op, left, right)
+	}
+
+	return exprValue{}, fmt.Errorf("Unknown operator \"%v\"", node.op)
+}
+
+func (node *exprBinaryNode) evalShortCircuit(variables map[string]string, left exprValue, shortCircuitOn bool) (exprValue, error) {
+	leftBool, ok := left.asBool()
+	if !ok {
+		return exprValue{}, fmt.Errorf("\"%v\" requires boolean operands", node.op)
+	}
+
+	if leftBool == shortCircuitOn {
+		return exprValue{kind: exprValBool, boolValue: shortCircuitOn}, nil
+	}
+
+	right, err := node.right.Eval(variables)
+	if err != nil {
+		return exprValue{}, err
+	}
+
+	rightBool, ok := right.asBool()
+	if !ok {
+		return exprValue{}, fmt.Errorf("\"%v\" requires boolean operands", node.op)
+	}
+
+	return exprValue{kind: exprValBool, boolValue: rightBool}, nil
+}
+
+func valuesEqual(left, right exprValue) bool {
+	if leftFloat, ok := left.asFloat(); ok {
+		if rightFloat, ok := right.asFloat(); ok {
+			return leftFloat == rightFloat
+		}
+	}
+
+	return left.String() == right.String()
+}
+
+func compareValues(op string, left, right exprValue) (exprValue, error) {
+	leftFloat, leftOk := left.asFloat()
+	rightFloat, rightOk := right.asFloat()
+	if !leftOk || !rightOk {
+		return exprValue{}, fmt.Errorf("\"%v\" requires numeric operands", op)
+	}
+
+	var result bool
+	switch op {
+	case "lt", "<":
+		result = leftFloat < rightFloat
+	case "le", "<=":
+		result = leftFloat <= rightFloat
+	case "gt", ">":
+		result = leftFloat > rightFloat
+	case "ge", ">=":
+		result = leftFloat >= rightFloat
+	}
+
+	return exprValue{kind: exprValBool, boolValue: result}, nil
+}
+
+func arithmeticValues(op string, left, right exprValue) (exprValue, error) {
+	leftFloat, leftOk := left.asFloat()
+	rightFloat, rightOk := right.asFloat()
+	if !leftOk || !rightOk {
+		return exprValue{}, fmt.Errorf("\"%v\" requires numeric operands", op)
+	}
+
+	if (op == "div" || op == "/") && rightFloat == 0 {
+		return exprValue{}, fmt.Errorf("Division by zero")
+	}
+
+	var result float64
+	switch op {
+	case "add", "+":
+		result = leftFloat + rightFloat
+	case "sub", "-":
+		result = leftFloat - rightFloat
+	case "mul", "*":
+		result = leftFloat * rightFloat
+	case "div", "/":
+		result = leftFloat / rightFloat
+	}
+
+	if left.kind == exprValInt && right.kind == exprValInt && op != "div" && op != "/" {
+		return exprValue{kind: exprValInt, intValue: int64(result)}, nil
+	}
+
+	return exprValue{kind: exprValFloat, floatVal: result}, nil
+}
+
+// operatorBindingPower returns the left binding power of a binary operator,
+// or 0 if value isn't one. Higher binds tighter, giving */ precedence over
+// +-, which in turn binds tighter than comparisons, then and, then or.
+func operatorBindingPower(value string) int {
+	switch value {
+	case "or", "||":
+		return 1
+	case "and", "&&":
+		return 2
+	case "eq", "==", "ne", "!=", "lt", "<", "le", "<=", "gt", ">", "ge", ">=":
+		return 3
+	case "add", "+", "sub", "-":
+		return 4
+	case "mul", "*", "div", "/":
+		return 5
+	default:
+		return 0
+	}
+}
+
+// exprParser builds an AST from the token stream produced by exprLexer
+// using a Pratt (precedence-climbing) parser
+type exprParser struct {
+	lexer   *exprLexer
+	current exprToken
+}
+
+func newExprParser(raw string) (*exprParser, error) {
+	parser := &exprParser{lexer: newExprLexer(raw)}
+
+	if err := parser.advance(); err != nil {
+		return nil, err
+	}
+
+	return parser, nil
+}
+
+func (parser *exprParser) advance() error {
+	token, err := parser.lexer.NextToken()
+	if err != nil {
+		return err
+	}
+
+	parser.current = token
+	return nil
+}
+
+func (parser *exprParser) parseExpression(minBindingPower int) (exprNode, error) {
+	left, err := parser.parsePrefix()
+	if err != nil {
+		return nil, err
+	}
+
+	for parser.current.tokenType == exprOperator || parser.current.tokenType == exprIdent {
+		bindingPower := operatorBindingPower(parser.current.value)
+		if bindingPower == 0 || bindingPower < minBindingPower {
+			break
+		}
+
+		op := parser.current.value
+		if err := parser.advance(); err != nil {
+			return nil, err
+		}
+
+		right, err := parser.parseExpression(bindingPower + 1)
+		if err != nil {
+			return nil, err
+		}
+
+		left = &exprBinaryNode{op: op, left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (parser *exprParser) parsePrefix() (exprNode, error) {
+	token := parser.current
+
+	switch {
+	case token.tokenType == exprNumber:
+		if err := parser.advance(); err != nil {
+			return nil, err
+		}
+		return parseNumberLiteral(token.value)
+	case token.tokenType == exprString:
+		if err := parser.advance(); err != nil {
+			return nil, err
+		}
+		return &exprLiteralNode{value: exprValue{kind: exprValString, strValue: token.value}}, nil
+	case token.tokenType == exprVariable:
+		if err := parser.advance(); err != nil {
+			return nil, err
+		}
+		return &exprVarNode{name: token.value}, nil
+	case token.tokenType == exprIdent && (token.value == "true" || token.value == "false"):
+		if err := parser.advance(); err != nil {
+			return nil, err
+		}
+		return &exprLiteralNode{value: exprValue{kind: exprValBool, boolValue: token.value == "true"}}, nil
+	case token.tokenType == exprIdent && token.value == "not":
+		if err := parser.advance(); err != nil {
+			return nil, err
+		}
+		operand, err := parser.parseExpression(operatorBindingPower("mul"))
+		if err != nil {
+			return nil, err
+		}
+		return &exprUnaryNode{op: "not", operand: operand}, nil
+	case token.tokenType == exprOperator && (token.value == "!" || token.value == "-"):
+		if err := parser.advance(); err != nil {
+			return nil, err
+		}
+		operand, err := parser.parseExpression(operatorBindingPower("mul"))
+		if err != nil {
+			return nil, err
+		}
+		return &exprUnaryNode{op: token.value, operand: operand}, nil
+	case token.tokenType == exprLParen:
+		if err := parser.advance(); err != nil {
+			return nil, err
+		}
+		node, err := parser.parseExpression(0)
+		if err != nil {
+			return nil, err
+		} else if parser.current.tokenType != exprRParen {
+			return nil, fmt.Errorf("Expected \")\"")
+		}
+		if err := parser.advance(); err != nil {
+			return nil, err
+		}
+		return node, nil
+	}
+
+	return nil, fmt.Errorf("Unexpected token \"%v\" in expression", token.value)
+}
+
+func parseNumberLiteral(raw string) (exprNode, error) {
+	if strings.Contains(raw, ".") {
+		floatValue, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid number \"%v\"", raw)
+		}
+		return &exprLiteralNode{value: exprValue{kind: exprValFloat, floatVal: floatValue}}, nil
+	}
+
+	intValue, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid number \"%v\"", raw)
+	}
+
+	return &exprLiteralNode{value: exprValue{kind: exprValInt, intValue: intValue}}, nil
+}
+
+// EvaluateExpression parses and evaluates the contents of a $(...) token,
+// returning the result coerced to a string suitable for use as a
+// SetCommand value or a flag argument.
+func EvaluateExpression(raw string, variables map[string]string) (string, error) {
+	parser, err := newExprParser(raw)
+	if err != nil {
+		return "", err
+	}
+
+	node, err := parser.parseExpression(0)
+	if err != nil {
+		return "", err
+	} else if parser.current.tokenType != exprEOF {
+		return "", fmt.Errorf("Unexpected trailing token \"%v\" in expression", parser.current.value)
+	}
+
+	value, err := node.Eval(variables)
+	if err != nil {
+		return "", err
+	}
+
+	return value.String(), nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvaluateExpressionAppliesArithmeticOperatorPrecedence(t *testing.T) {
+	value, err := EvaluateExpression("2 + 3 * 4", map[string]string{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "14", value)
+}
+
+func TestEvaluateExpressionAppliesComparisonOperatorPrecedenceOverAnd(t *testing.T) {
+	value, err := EvaluateExpression("1 lt 2 and 3 gt 2", map[string]string{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "true", value)
+}
+
+func TestEvaluateExpressionParenthesesOverridePrecedence(t *testing.T) {
+	value, err := EvaluateExpression("(2 + 3) * 4", map[string]string{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "20", value)
+}
+
+func TestEvaluateExpressionShortCircuitsAndWithoutEvaluatingTheRightOperand(t *testing.T) {
+	value, err := EvaluateExpression("false and $undefined", map[string]string{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "false", value)
+}
+
+func TestEvaluateExpressionShortCircuitsOrWithoutEvaluatingTheRightOperand(t *testing.T) {
+	value, err := EvaluateExpression("true or $undefined", map[string]string{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "true", value)
+}
+
+func TestEvaluateExpressionDoesNotShortCircuitAndWhenTheLeftOperandIsTrue(t *testing.T) {
+	_, err := EvaluateExpression("true and $undefined", map[string]string{})
+
+	assert.Error(t, err)
+}
+
+func TestEvaluateExpressionReturnsErrorOnDivisionByZero(t *testing.T) {
+	_, err := EvaluateExpression("1 / 0", map[string]string{})
+
+	assert.Error(t, err)
+}
+
+func TestEvaluateExpressionReturnsErrorForUnknownVariableReference(t *testing.T) {
+	_, err := EvaluateExpression("$doesnotexist", map[string]string{})
+
+	assert.Error(t, err)
+}
+
+func TestEvaluateExpressionResolvesKnownVariableReferences(t *testing.T) {
+	value, err := EvaluateExpression("$count + 1", map[string]string{"count": "4"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "5", value)
+}
+
+func TestEvaluateExpressionComparesStringLiterals(t *testing.T) {
+	value, err := EvaluateExpression("$os eq \"linux\"", map[string]string{"os": "linux"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "true", value)
+}
+
+func TestEvaluateExpressionAppliesNotOperator(t *testing.T) {
+	value, err := EvaluateExpression("not false", map[string]string{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "true", value)
+}
+
+func TestSetCommandResolveValueReturnsLiteralValueForNonExpressionTokens(t *testing.T) {
+	setCommand := &SetCommand{
+		variable: wordToken("mycolor"),
+		value:    wordToken("blue"),
+	}
+
+	value, err := setCommand.ResolveValue(map[string]string{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "blue", value)
+}
+
+func TestSetCommandResolveValueEvaluatesExpressionTokens(t *testing.T) {
+	setCommand := &SetCommand{
+		variable:    wordToken("total"),
+		value:       &ConfigToken{tokenType: CtkExpression, value: "2 * 3"},
+		inputSource: "test",
+	}
+
+	value, err := setCommand.ResolveValue(map[string]string{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "6", value)
+}
+
+func TestSetCommandResolveValueWrapsExpressionErrorsWithInputSource(t *testing.T) {
+	setCommand := &SetCommand{
+		variable:    wordToken("total"),
+		value:       &ConfigToken{tokenType: CtkExpression, value: "1 / 0"},
+		inputSource: "test.grvrc",
+	}
+
+	_, err := setCommand.ResolveValue(map[string]string{})
+
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "test.grvrc")
+	}
+}
+
+func TestConfigFlagValueResolveStringValueReturnsLiteralValueForNonExpressionTokens(t *testing.T) {
+	flagValue := ConfigFlagValue{
+		valueToken: wordToken("origin"),
+		strValue:   "origin",
+	}
+
+	value, err := flagValue.ResolveStringValue(map[string]string{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "origin", value)
+}
+
+func TestConfigFlagValueResolveStringValueEvaluatesExpressionTokens(t *testing.T) {
+	flagValue := ConfigFlagValue{
+		valueToken: &ConfigToken{tokenType: CtkExpression, value: "1 + 1"},
+	}
+
+	value, err := flagValue.ResolveStringValue(map[string]string{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "2", value)
+}
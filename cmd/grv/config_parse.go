@@ -5,6 +5,10 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 )
 
 const (
@@ -19,6 +23,34 @@ const (
 	vsplitCommand    = "vsplit"
 	hsplitCommand    = "hsplit"
 	splitCommand     = "split"
+	whenCommand      = "when"
+	selectCommand    = "select"
+	sourceCommand    = "source"
+)
+
+// ConfigTokenType values used to delimit a conditional command block.
+// These are allocated above the token types produced for the rest of the
+// config language so they can't collide with values defined elsewhere.
+const (
+	// CtkBlockOpen represents the start of a conditional command block: '{'
+	CtkBlockOpen ConfigTokenType = iota + 100
+	// CtkBlockClose represents the end of a conditional command block: '}'
+	CtkBlockClose
+	// CtkCaseArrow represents the '=>' token separating a select case value
+	// from the commands it guards
+	CtkCaseArrow
+	// CtkExpression represents a $(...) arithmetic/comparison expression
+	// value, e.g. "$(2 * 4)" or "$($os == \"linux\")". Its token value holds
+	// the raw text between the parens; the expression itself is lexed and
+	// evaluated separately, by config_expr.go, against config variables
+	// known at the point the command is applied.
+	CtkExpression
+	// CtkExpressibleWord is never produced by the scanner. A fixed-arity
+	// commandDescriptor uses it in place of CtkWord in tokenTypes to mark
+	// the one token position (a SetCommand value) that may be written as
+	// either a literal word or a $(...) expression. Flag arguments don't go
+	// through tokenTypes; parseFlagValue accepts expressions for them itself.
+	CtkExpressibleWord
 )
 
 type commandConstructor func(parser *ConfigParser, commandToken *ConfigToken, tokens []*ConfigToken) (ConfigCommand, error)
@@ -30,12 +62,30 @@ type ConfigCommand interface {
 
 // SetCommand contains state for setting a config variable to a value
 type SetCommand struct {
-	variable *ConfigToken
-	value    *ConfigToken
+	variable    *ConfigToken
+	value       *ConfigToken
+	inputSource string
 }
 
 func (setCommand *SetCommand) configCommand() {}
 
+// ResolveValue returns the value to set variable to. If the value was
+// written using $(...) syntax it's evaluated as an expression against
+// variables (the config variables already applied when this command is
+// reached); otherwise the token's literal value is returned unchanged.
+func (setCommand *SetCommand) ResolveValue(variables map[string]string) (string, error) {
+	if setCommand.value.tokenType != CtkExpression {
+		return setCommand.value.value, nil
+	}
+
+	value, err := EvaluateExpression(setCommand.value.value, variables)
+	if err != nil {
+		return "", generateConfigError(setCommand.inputSource, setCommand.value, "%v", err)
+	}
+
+	return value, nil
+}
+
 // ThemeCommand contains state for setting a components values for on a theme
 type ThemeCommand struct {
 	name      *ConfigToken
@@ -85,6 +135,7 @@ func (removeTabCommand *RemoveTabCommand) configCommand() {}
 type AddViewCommand struct {
 	view *ConfigToken
 	args []*ConfigToken
+	name *ConfigToken
 }
 
 func (addViewCommand *AddViewCommand) configCommand() {}
@@ -95,24 +146,130 @@ type SplitViewCommand struct {
 	orientation ContainerOrientation
 	view        *ConfigToken
 	args        []*ConfigToken
+	name        *ConfigToken
+	ratio       float64
+	hasRatio    bool
+	focus       bool
 }
 
 func (splitViewCommand *SplitViewCommand) configCommand() {}
 
+// ConfigFlagType describes the type of value a commandFlagSpec accepts
+type ConfigFlagType int
+
+// The set of value types a command flag can declare
+const (
+	CftString ConfigFlagType = iota
+	CftInt
+	CftFloat
+	CftBool
+	CftEnum
+	CftColor
+)
+
+// commandFlagSpec declares a single flag a command descriptor accepts,
+// modelled loosely on kingpin's flag definitions
+type commandFlagSpec struct {
+	longName     string
+	shortName    string
+	flagType     ConfigFlagType
+	required     bool
+	defaultValue string
+	repeated     bool
+	enumValues   []string
+}
+
+// ConfigFlagValue is the typed value parseFlaggedCommand produces for a
+// single flag occurrence
+type ConfigFlagValue struct {
+	flagType    ConfigFlagType
+	optionToken *ConfigToken
+	valueToken  *ConfigToken
+	strValue    string
+	intValue    int
+	floatValue  float64
+	boolValue   bool
+	// repeats holds any further occurrences of a flag declared with
+	// commandFlagSpec.repeated, beyond this first one, in the order they
+	// were seen
+	repeats []ConfigFlagValue
+}
+
+// ResolveStringValue returns the flag's value, evaluating it as a $(...)
+// expression against variables first if it was written that way.
+func (flagValue ConfigFlagValue) ResolveStringValue(variables map[string]string) (string, error) {
+	if flagValue.valueToken == nil || flagValue.valueToken.tokenType != CtkExpression {
+		return flagValue.strValue, nil
+	}
+
+	return EvaluateExpression(flagValue.valueToken.value, variables)
+}
+
+// ConfigContext provides the repo/environment state a conditional config
+// block predicate is evaluated against. Predicates are evaluated at
+// command-execution time rather than at parse time.
+type ConfigContext interface {
+	CurrentBranch() string
+	OS() string
+	HasRemote(remote string) bool
+	GitVersion() string
+}
+
+// WhenCommand contains state for a block of commands that is only
+// executed when every predicate in predicates holds true
+type WhenCommand struct {
+	predicates []*ConfigToken
+	commands   []ConfigCommand
+}
+
+func (whenCommand *WhenCommand) configCommand() {}
+
+// SelectBranch represents a single "value => commands" case of a
+// SelectCommand, or the "default" fallback case
+type SelectBranch struct {
+	value     *ConfigToken
+	isDefault bool
+	command   ConfigCommand
+}
+
+// SelectCommand contains state for a block of commands where exactly
+// one branch is executed, chosen by matching on on against the
+// predicate named on
+type SelectCommand struct {
+	on       *ConfigToken
+	branches []*SelectBranch
+}
+
+func (selectCommand *SelectCommand) configCommand() {}
+
+type blockCommandConstructor func(parser *ConfigParser, commandToken *ConfigToken, tokens []*ConfigToken) (ConfigCommand, error)
+
+type flaggedCommandConstructor func(parser *ConfigParser, commandToken *ConfigToken, flags map[string]ConfigFlagValue, positional []*ConfigToken) (ConfigCommand, error)
+
 type commandDescriptor struct {
-	tokenTypes  []ConfigTokenType
-	varArgs     bool
-	constructor commandConstructor
+	tokenTypes       []ConfigTokenType
+	varArgs          bool
+	blockCommand     bool
+	blockConstructor blockCommandConstructor
+	flagged          bool
+	flags            []commandFlagSpec
+	flagConstructor  flaggedCommandConstructor
+	constructor      commandConstructor
+	// source marks the source/include directive. It's handled directly by
+	// Parse rather than parseCommand, since it queues pendingSources onto
+	// parser.childQueue rather than producing a ConfigCommand
+	source bool
 }
 
 var commandDescriptors = map[string]*commandDescriptor{
 	setCommand: {
-		tokenTypes:  []ConfigTokenType{CtkWord, CtkWord},
+		tokenTypes:  []ConfigTokenType{CtkWord, CtkExpressibleWord},
 		constructor: setCommandConstructor,
 	},
 	themeCommand: {
-		tokenTypes:  []ConfigTokenType{CtkOption, CtkWord, CtkOption, CtkWord, CtkOption, CtkWord, CtkOption, CtkWord},
-		constructor: themeCommandConstructor,
+		flagged:         true,
+		flags:           themeCommandFlagSpecs,
+		flagConstructor: themeCommandConstructor,
 	},
 	mapCommand: {
 		tokenTypes:  []ConfigTokenType{CtkWord, CtkWord, CtkWord},
@@ -133,27 +290,80 @@ var commandDescriptors = map[string]*commandDescriptor{
 		constructor: newRemoveTabCommandConstructor,
 	},
 	addviewCommand: {
-		varArgs:     true,
-		constructor: addViewCommandConstructor,
+		flagged:         true,
+		flags:           addViewCommandFlagSpecs,
+		flagConstructor: addViewCommandConstructor,
 	},
 	vsplitCommand: {
-		varArgs:     true,
-		constructor: splitViewCommandConstructor,
+		flagged:         true,
+		flags:           splitViewCommandFlagSpecs,
+		flagConstructor: splitViewCommandConstructor,
 	},
 	hsplitCommand: {
-		varArgs:     true,
-		constructor: splitViewCommandConstructor,
+		flagged:         true,
+		flags:           splitViewCommandFlagSpecs,
+		flagConstructor: splitViewCommandConstructor,
 	},
 	splitCommand: {
-		varArgs:     true,
-		constructor: splitViewCommandConstructor,
+		flagged:         true,
+		flags:           splitViewCommandFlagSpecs,
+		flagConstructor: splitViewCommandConstructor,
 	},
+	sourceCommand: {
+		source: true,
+	},
+}
+
+var themeCommandFlagSpecs = []commandFlagSpec{
+	{longName: "--name", flagType: CftString, required: true},
+	{longName: "--component", flagType: CftString, required: true},
+	{longName: "--bgcolor", flagType: CftColor},
+	{longName: "--fgcolor", flagType: CftColor},
+}
+
+var addViewCommandFlagSpecs = []commandFlagSpec{
+	{longName: "--name", flagType: CftString},
+}
+
+var splitViewCommandFlagSpecs = []commandFlagSpec{
+	{longName: "--name", flagType: CftString},
+	{longName: "--ratio", flagType: CftFloat},
+	{longName: "--focus", flagType: CftBool},
+}
+
+// The when/select descriptors are registered from init rather than the
+// map literal above: their constructors recurse back into parseCommand,
+// and parseCommand looks up commandDescriptors, which would otherwise be
+// an initialisation cycle.
+func init() {
+	commandDescriptors[whenCommand] = &commandDescriptor{
+		blockCommand:     true,
+		blockConstructor: whenCommandConstructor,
+	}
+	commandDescriptors[selectCommand] = &commandDescriptor{
+		blockCommand:     true,
+		blockConstructor: selectCommandConstructor,
+	}
+}
+
+// pendingSource is a source command argument that matched a file but hasn't
+// been opened yet. Queued sources are opened lazily, one at a time, as they
+// become the active child - so a parse error raised before a later queued
+// source is reached never leaves its file descriptor open.
+type pendingSource struct {
+	path        string
+	pathToken   *ConfigToken
+	openSources []string
 }
 
 // ConfigParser is a component capable of parsing config into commands
 type ConfigParser struct {
 	scanner     *ConfigScanner
 	inputSource string
+	closer      io.Closer
+	openSources []string
+	childQueue  []*pendingSource
+	activeChild *ConfigParser
 }
 
 // NewConfigParser creates a new ConfigParser which will read input from the provided reader
@@ -164,12 +374,47 @@ func NewConfigParser(reader io.Reader, inputSource string) *ConfigParser {
 	}
 }
 
+func newSourcedConfigParser(file *os.File, inputSource string, openSources []string) *ConfigParser {
+	return &ConfigParser{
+		scanner:     NewConfigScanner(file),
+		inputSource: inputSource,
+		closer:      file,
+		openSources: openSources,
+	}
+}
+
 // Parse returns the next command from the input stream
 // eof is set to true if the end of the input stream has been reached
 func (parser *ConfigParser) Parse() (command ConfigCommand, eof bool, err error) {
-	var token *ConfigToken
-
 	for {
+		if parser.activeChild != nil {
+			command, eof, err = parser.activeChild.Parse()
+			if eof || err != nil {
+				if parser.activeChild.closer != nil {
+					parser.activeChild.closer.Close()
+				}
+				parser.activeChild = nil
+
+				if err == nil {
+					continue
+				}
+			}
+
+			return
+		}
+
+		if len(parser.childQueue) > 0 {
+			var pending *pendingSource
+			pending, parser.childQueue = parser.childQueue[0], parser.childQueue[1:]
+
+			if err = parser.activateChild(pending); err != nil {
+				return
+			}
+
+			continue
+		}
+
+		var token *ConfigToken
 		token, err = parser.scan()
 		if err != nil {
 			return
@@ -177,6 +422,14 @@ func (parser *ConfigParser) Parse() (command ConfigCommand, eof bool, err error)
 
 		switch token.tokenType {
 		case CtkWord:
+			if commandDescriptor, ok := commandDescriptors[token.value]; ok && commandDescriptor.source {
+				if err = parser.parseSourceCommand(token); err != nil {
+					parser.discardTokensUntilNextCommand()
+					return
+				}
+				continue
+			}
+
 			command, eof, err = parser.parseCommand(token)
 		case CtkTerminator:
 			continue
@@ -200,6 +453,103 @@ func (parser *ConfigParser) Parse() (command ConfigCommand, eof bool, err error)
 	return
 }
 
+// parseSourceCommand resolves the path argument of a source command relative
+// to this parser's input source, expands it as a glob and queues a
+// pendingSource for each match. Queued sources are opened lazily, as they're
+// drained by Parse, so a match is never left with an open file descriptor
+// if parsing aborts before its turn comes up.
+func (parser *ConfigParser) parseSourceCommand(commandToken *ConfigToken) error {
+	pathToken, err := parser.scan()
+	if err != nil {
+		return err
+	} else if pathToken.tokenType != CtkWord {
+		return parser.generateParseError(pathToken, "Expected path but got \"%v\"", pathToken.value)
+	}
+
+	terminator, err := parser.scan()
+	if err != nil {
+		return err
+	} else if terminator.tokenType != CtkTerminator && terminator.tokenType != CtkEOF {
+		return parser.generateParseError(terminator, "Expected end of %v command but got \"%v\"", sourceCommand, terminator.value)
+	}
+
+	pattern := pathToken.value
+
+	if strings.HasPrefix(pattern, "~") {
+		if home, homeErr := os.UserHomeDir(); homeErr == nil {
+			pattern = filepath.Join(home, strings.TrimPrefix(pattern, "~"))
+		}
+	}
+
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(filepath.Dir(parser.inputSource), pattern)
+	}
+
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return parser.generateParseError(pathToken, "Invalid %v pattern \"%v\": %v", sourceCommand, pathToken.value, err)
+	} else if len(matches) == 0 {
+		if isGlobPattern(pattern) {
+			// A glob with no matches is a no-op, like vim/shell plugin
+			// loading (e.g. "source ~/.grv/plugins/*.grvrc" against an
+			// empty or absent plugins directory) - not an error.
+			return nil
+		}
+
+		matches = []string{pattern}
+	}
+
+	openSources := append(append([]string{}, parser.openSources...), parser.inputSource)
+
+	for _, match := range matches {
+		if cycleErr := parser.checkSourceCycle(match); cycleErr != nil {
+			return parser.generateParseError(pathToken, "%v", cycleErr)
+		}
+
+		parser.childQueue = append(parser.childQueue, &pendingSource{
+			path:        match,
+			pathToken:   pathToken,
+			openSources: openSources,
+		})
+	}
+
+	return nil
+}
+
+// isGlobPattern reports whether path contains any of the meta-characters
+// filepath.Glob treats specially. It's used to tell a literal path (missing
+// is an error) apart from a glob pattern (no matches is a no-op).
+func isGlobPattern(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// activateChild opens pending's file and makes it the active child. This is
+// where a queued source is finally opened, rather than when it was queued,
+// so only the child currently being parsed ever holds an open file.
+func (parser *ConfigParser) activateChild(pending *pendingSource) error {
+	file, err := os.Open(pending.path)
+	if err != nil {
+		return parser.generateParseError(pending.pathToken, "Unable to source \"%v\": %v", pending.path, err)
+	}
+
+	parser.activeChild = newSourcedConfigParser(file, pending.path, pending.openSources)
+	return nil
+}
+
+// checkSourceCycle returns an error if path is already open somewhere in
+// this parser's chain of source commands
+func (parser *ConfigParser) checkSourceCycle(path string) error {
+	chain := append(append([]string{}, parser.openSources...), parser.inputSource)
+
+	for _, open := range chain {
+		if open == path {
+			return fmt.Errorf("source cycle: %v -> %v", strings.Join(chain, " -> "), path)
+		}
+	}
+
+	return nil
+}
+
 // InputSource returns the text description of the input source
 func (parser *ConfigParser) InputSource() string {
 	return parser.inputSource
@@ -262,10 +612,23 @@ func (parser *ConfigParser) parseCommand(commandToken *ConfigToken) (command Con
 		return
 	}
 
+	if commandDescriptor.source {
+		err = parser.generateParseError(commandToken, "%v is only valid as a top level command, not inside a block", commandToken.value)
+		return
+	}
+
 	if commandDescriptor.varArgs {
 		return parser.parseVarArgsCommand(commandDescriptor, commandToken)
 	}
 
+	if commandDescriptor.blockCommand {
+		return parser.parseBlockCommand(commandDescriptor, commandToken)
+	}
+
+	if commandDescriptor.flagged {
+		return parser.parseFlaggedCommand(commandDescriptor, commandToken)
+	}
+
 	var tokens []*ConfigToken
 
 	for i := 0; i < len(commandDescriptor.tokenTypes); i++ {
@@ -283,6 +646,12 @@ func (parser *ConfigParser) parseCommand(commandToken *ConfigToken) (command Con
 			err = parser.generateParseError(token, "Unexpected EOF")
 			eof = true
 			return
+		case expectedConfigTokenType == CtkExpressibleWord:
+			if token.tokenType != CtkWord && token.tokenType != CtkExpression {
+				err = parser.generateParseError(token, "Expected %v but got %v: \"%v\"",
+					ConfigTokenName(CtkWord), ConfigTokenName(token.tokenType), token.value)
+				return
+			}
 		case token.tokenType != expectedConfigTokenType:
 			err = parser.generateParseError(token, "Expected %v but got %v: \"%v\"",
 				ConfigTokenName(expectedConfigTokenType), ConfigTokenName(token.tokenType), token.value)
@@ -324,33 +693,208 @@ OuterLoop:
 	return
 }
 
-func setCommandConstructor(parser *ConfigParser, commandToken *ConfigToken, tokens []*ConfigToken) (ConfigCommand, error) {
-	return &SetCommand{
-		variable: tokens[0],
-		value:    tokens[1],
-	}, nil
+// parseFlaggedCommand consumes CtkOption flags, in any order, up to the
+// first positional argument, validates them against commandDescriptor.flags
+// and hands the result to the command's flagConstructor. Once a positional
+// argument is seen, every remaining token - including option-like ones - is
+// collected as positional too, so a view's own arguments (e.g. "grep
+// --ignore-case") are forwarded verbatim rather than parsed as flags: flags
+// must come before positionals.
+func (parser *ConfigParser) parseFlaggedCommand(commandDescriptor *commandDescriptor, commandToken *ConfigToken) (command ConfigCommand, eof bool, err error) {
+	flagValues := make(map[string]ConfigFlagValue)
+	var positional []*ConfigToken
+
+OuterLoop:
+	for {
+		var token *ConfigToken
+		token, err = parser.scan()
+
+		switch {
+		case err != nil:
+			return
+		case token.err != nil:
+			err = parser.generateParseError(token, "Syntax Error")
+			return
+		case token.tokenType == CtkEOF:
+			break OuterLoop
+		case token.tokenType == CtkTerminator:
+			break OuterLoop
+		case len(positional) == 0 && token.tokenType == CtkOption:
+			flagSpec := findFlagSpec(commandDescriptor.flags, token.value)
+			if flagSpec == nil {
+				err = parser.generateParseError(token, "Invalid flag \"%v\"", token.value)
+				return
+			}
+
+			var flagValue ConfigFlagValue
+			flagValue, err = parser.parseFlagValue(flagSpec, token)
+			if err != nil {
+				return
+			}
+
+			if existing, ok := flagValues[flagSpec.longName]; ok {
+				if !flagSpec.repeated {
+					err = parser.generateParseError(token, "Flag \"%v\" specified more than once", flagSpec.longName)
+					return
+				}
+
+				existing.repeats = append(existing.repeats, flagValue)
+				flagValues[flagSpec.longName] = existing
+			} else {
+				flagValues[flagSpec.longName] = flagValue
+			}
+		default:
+			positional = append(positional, token)
+		}
+	}
+
+	for _, flagSpec := range commandDescriptor.flags {
+		if _, ok := flagValues[flagSpec.longName]; ok {
+			continue
+		}
+
+		if flagSpec.required {
+			err = parser.generateParseError(commandToken, "%v requires flag \"%v\"", commandToken.value, flagSpec.longName)
+			return
+		}
+
+		if flagSpec.defaultValue != "" {
+			var defaultFlagValue ConfigFlagValue
+			defaultFlagValue, err = parser.parseDefaultFlagValue(flagSpec)
+			if err != nil {
+				return
+			}
+
+			flagValues[flagSpec.longName] = defaultFlagValue
+		}
+	}
+
+	command, err = commandDescriptor.flagConstructor(parser, commandToken, flagValues, positional)
+	return
 }
 
-func themeCommandConstructor(parser *ConfigParser, commandToken *ConfigToken, tokens []*ConfigToken) (ConfigCommand, error) {
-	themeCommand := &ThemeCommand{}
+// parseDefaultFlagValue converts a commandFlagSpec's defaultValue into the
+// typed ConfigFlagValue used when the flag is omitted entirely
+func (parser *ConfigParser) parseDefaultFlagValue(flagSpec commandFlagSpec) (flagValue ConfigFlagValue, err error) {
+	flagValue = ConfigFlagValue{flagType: flagSpec.flagType, strValue: flagSpec.defaultValue}
+
+	switch flagSpec.flagType {
+	case CftInt:
+		if flagValue.intValue, err = strconv.Atoi(flagSpec.defaultValue); err != nil {
+			err = fmt.Errorf("Invalid default value \"%v\" for flag \"%v\": expected an integer", flagSpec.defaultValue, flagSpec.longName)
+		}
+	case CftFloat:
+		if flagValue.floatValue, err = strconv.ParseFloat(flagSpec.defaultValue, 64); err != nil {
+			err = fmt.Errorf("Invalid default value \"%v\" for flag \"%v\": expected a number", flagSpec.defaultValue, flagSpec.longName)
+		}
+	case CftBool:
+		if flagValue.boolValue, err = strconv.ParseBool(flagSpec.defaultValue); err != nil {
+			err = fmt.Errorf("Invalid default value \"%v\" for flag \"%v\": expected a bool", flagSpec.defaultValue, flagSpec.longName)
+		}
+	case CftEnum:
+		if !isValidEnumValue(flagSpec.enumValues, flagSpec.defaultValue) {
+			err = fmt.Errorf("Invalid default value \"%v\" for flag \"%v\": expected one of %v", flagSpec.defaultValue, flagSpec.longName, flagSpec.enumValues)
+		}
+	}
+
+	return
+}
+
+func (parser *ConfigParser) parseFlagValue(flagSpec *commandFlagSpec, flagToken *ConfigToken) (flagValue ConfigFlagValue, err error) {
+	if flagSpec.flagType == CftBool {
+		flagValue = ConfigFlagValue{flagType: CftBool, optionToken: flagToken, boolValue: true}
+		return
+	}
+
+	var valueToken *ConfigToken
+	valueToken, err = parser.scan()
+	if err != nil {
+		return
+	} else if valueToken.tokenType != CtkWord && valueToken.tokenType != CtkExpression {
+		err = parser.generateParseError(valueToken, "Expected value for flag \"%v\" but got \"%v\"", flagToken.value, valueToken.value)
+		return
+	}
+
+	flagValue = ConfigFlagValue{flagType: flagSpec.flagType, optionToken: flagToken, valueToken: valueToken}
 
-	optionSetters := map[string]func(*ConfigToken){
-		"--name":      func(name *ConfigToken) { themeCommand.name = name },
-		"--component": func(component *ConfigToken) { themeCommand.component = component },
-		"--bgcolor":   func(bgcolor *ConfigToken) { themeCommand.bgcolor = bgcolor },
-		"--fgcolor":   func(fgcolor *ConfigToken) { themeCommand.fgcolor = fgcolor },
+	if valueToken.tokenType == CtkExpression {
+		if flagSpec.flagType != CftString {
+			err = parser.generateParseError(valueToken, "Flag \"%v\" does not accept a $(...) expression: its value is needed at parse time, before config variables exist", flagToken.value)
+			return
+		}
+
+		// Typed coercion is deferred until the flag value is resolved via
+		// ResolveStringValue, once config variables are available
+		return
 	}
 
-	for i := 0; i+1 < len(tokens); i += 2 {
-		optionToken := tokens[i]
-		valueToken := tokens[i+1]
+	switch flagSpec.flagType {
+	case CftString, CftColor:
+		flagValue.strValue = valueToken.value
+	case CftInt:
+		if flagValue.intValue, err = strconv.Atoi(valueToken.value); err != nil {
+			err = parser.generateParseError(valueToken, "Expected integer value for flag \"%v\" but got \"%v\"", flagToken.value, valueToken.value)
+		}
+	case CftFloat:
+		if flagValue.floatValue, err = strconv.ParseFloat(valueToken.value, 64); err != nil {
+			err = parser.generateParseError(valueToken, "Expected numeric value for flag \"%v\" but got \"%v\"", flagToken.value, valueToken.value)
+		}
+	case CftEnum:
+		if !isValidEnumValue(flagSpec.enumValues, valueToken.value) {
+			err = parser.generateParseError(valueToken, "Invalid value \"%v\" for flag \"%v\": expected one of %v", valueToken.value, flagToken.value, flagSpec.enumValues)
+		}
+		flagValue.strValue = valueToken.value
+	}
+
+	return
+}
 
-		optionSetter, ok := optionSetters[optionToken.value]
-		if !ok {
-			return nil, parser.generateParseError(optionToken, "Invalid option for theme command: \"%v\"", optionToken.value)
+func findFlagSpec(flagSpecs []commandFlagSpec, name string) *commandFlagSpec {
+	for i := range flagSpecs {
+		if flagSpecs[i].longName == name || (flagSpecs[i].shortName != "" && flagSpecs[i].shortName == name) {
+			return &flagSpecs[i]
 		}
+	}
+
+	return nil
+}
 
-		optionSetter(valueToken)
+func isValidEnumValue(enumValues []string, value string) bool {
+	for _, enumValue := range enumValues {
+		if enumValue == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func setCommandConstructor(parser *ConfigParser, commandToken *ConfigToken, tokens []*ConfigToken) (ConfigCommand, error) {
+	return &SetCommand{
+		variable:    tokens[0],
+		value:       tokens[1],
+		inputSource: parser.inputSource,
+	}, nil
+}
+
+func themeCommandConstructor(parser *ConfigParser, commandToken *ConfigToken, flags map[string]ConfigFlagValue, positional []*ConfigToken) (ConfigCommand, error) {
+	if len(positional) > 0 {
+		return nil, parser.generateParseError(commandToken, "Unexpected argument \"%v\" for theme command", positional[0].value)
+	}
+
+	themeCommand := &ThemeCommand{}
+
+	if flagValue, ok := flags["--name"]; ok {
+		themeCommand.name = flagValue.valueToken
+	}
+	if flagValue, ok := flags["--component"]; ok {
+		themeCommand.component = flagValue.valueToken
+	}
+	if flagValue, ok := flags["--bgcolor"]; ok {
+		themeCommand.bgcolor = flagValue.valueToken
+	}
+	if flagValue, ok := flags["--fgcolor"]; ok {
+		themeCommand.fgcolor = flagValue.valueToken
 	}
 
 	return themeCommand, nil
@@ -385,23 +929,270 @@ func newRemoveTabCommandConstructor(parser *ConfigParser, commandToken *ConfigTo
 	return &RemoveTabCommand{}, nil
 }
 
-func addViewCommandConstructor(parser *ConfigParser, commandToken *ConfigToken, tokens []*ConfigToken) (ConfigCommand, error) {
-	if len(tokens) < 1 {
+func addViewCommandConstructor(parser *ConfigParser, commandToken *ConfigToken, flags map[string]ConfigFlagValue, positional []*ConfigToken) (ConfigCommand, error) {
+	if len(positional) < 1 {
 		addViewCommand := commandToken.value
-		return nil, parser.generateParseError(commandToken, "Invalid %[1]v command. Usage: %[1]v [VIEW] [ARGS...]", addViewCommand)
+		return nil, parser.generateParseError(commandToken, "Invalid %[1]v command. Usage: %[1]v [FLAGS...] [VIEW] [ARGS...]", addViewCommand)
 	}
 
-	return &AddViewCommand{
-		view: tokens[0],
-		args: tokens[1:],
+	addViewCommand := &AddViewCommand{
+		view: positional[0],
+		args: positional[1:],
+	}
+
+	if flagValue, ok := flags["--name"]; ok {
+		addViewCommand.name = flagValue.valueToken
+	}
+
+	return addViewCommand, nil
+}
+
+func (parser *ConfigParser) parseBlockCommand(commandDescriptor *commandDescriptor, commandToken *ConfigToken) (command ConfigCommand, eof bool, err error) {
+	var leadingTokens []*ConfigToken
+
+	for {
+		var token *ConfigToken
+		token, err = parser.scan()
+
+		switch {
+		case err != nil:
+			return
+		case token.tokenType == CtkEOF:
+			err = parser.generateParseError(token, "Unexpected EOF")
+			eof = true
+			return
+		case token.tokenType == CtkTerminator:
+			continue
+		case token.tokenType == CtkBlockOpen:
+			command, err = commandDescriptor.blockConstructor(parser, commandToken, leadingTokens)
+			return
+		default:
+			leadingTokens = append(leadingTokens, token)
+		}
+	}
+}
+
+// scanPredicatesUntilBlockClose reads predicate tokens up to the matching
+// "}", leaving the scanner positioned immediately after it
+func (parser *ConfigParser) scanPredicatesUntilBlockClose() (predicates []*ConfigToken, err error) {
+	for {
+		var token *ConfigToken
+		token, err = parser.scan()
+
+		switch {
+		case err != nil:
+			return
+		case token.tokenType == CtkBlockClose:
+			return
+		case token.tokenType == CtkEOF:
+			err = parser.generateParseError(token, "Unmatched \"{\": missing \"}\"")
+			return
+		case token.tokenType == CtkTerminator:
+			continue
+		default:
+			predicates = append(predicates, token)
+		}
+	}
+}
+
+// parseCommandsUntilBlockClose parses commands, recursing through parseCommand
+// as normal, up to the matching "}", leaving the scanner positioned
+// immediately after it
+func (parser *ConfigParser) parseCommandsUntilBlockClose() (commands []ConfigCommand, err error) {
+	for {
+		var token *ConfigToken
+		token, err = parser.scan()
+
+		switch {
+		case err != nil:
+			return
+		case token.tokenType == CtkBlockClose:
+			return
+		case token.tokenType == CtkTerminator:
+			continue
+		case token.tokenType == CtkEOF:
+			err = parser.generateParseError(token, "Unmatched \"{\": missing \"}\"")
+			return
+		case token.tokenType == CtkWord:
+			var command ConfigCommand
+			var blockEOF bool
+
+			command, blockEOF, err = parser.parseCommand(token)
+			if err != nil {
+				return
+			} else if blockEOF {
+				err = parser.generateParseError(token, "Unmatched \"{\": missing \"}\"")
+				return
+			}
+
+			if command != nil {
+				commands = append(commands, command)
+			}
+		default:
+			err = parser.generateParseError(token, "Unexpected token \"%v\" inside block", token.value)
+			return
+		}
+	}
+}
+
+func whenCommandConstructor(parser *ConfigParser, commandToken *ConfigToken, tokens []*ConfigToken) (ConfigCommand, error) {
+	if len(tokens) > 0 {
+		return nil, parser.generateParseError(commandToken, "Invalid when command. Usage: when { PREDICATES } { COMMANDS }")
+	}
+
+	predicates, err := parser.scanPredicatesUntilBlockClose()
+	if err != nil {
+		return nil, err
+	}
+
+	var bodyOpenToken *ConfigToken
+
+	for {
+		bodyOpenToken, err = parser.scan()
+		if err != nil {
+			return nil, err
+		} else if bodyOpenToken.tokenType == CtkTerminator {
+			continue
+		} else if bodyOpenToken.tokenType != CtkBlockOpen {
+			return nil, parser.generateParseError(bodyOpenToken, "Expected \"{\" to begin when body but got \"%v\"", bodyOpenToken.value)
+		}
+
+		break
+	}
+
+	commands, err := parser.parseCommandsUntilBlockClose()
+	if err != nil {
+		return nil, err
+	}
+
+	return &WhenCommand{
+		predicates: predicates,
+		commands:   commands,
 	}, nil
 }
 
-func splitViewCommandConstructor(parser *ConfigParser, commandToken *ConfigToken, tokens []*ConfigToken) (ConfigCommand, error) {
+func selectCommandConstructor(parser *ConfigParser, commandToken *ConfigToken, tokens []*ConfigToken) (ConfigCommand, error) {
+	if len(tokens) != 2 || tokens[0].tokenType != CtkOption || tokens[0].value != "--on" {
+		return nil, parser.generateParseError(commandToken, "Invalid select command. Usage: select --on VARIABLE { VALUE => COMMAND; ... default => COMMAND }")
+	}
+
+	selectCommand := &SelectCommand{on: tokens[1]}
+
+	for {
+		caseToken, err := parser.scan()
+		switch {
+		case err != nil:
+			return nil, err
+		case caseToken.tokenType == CtkBlockClose:
+			return selectCommand, nil
+		case caseToken.tokenType == CtkTerminator:
+			continue
+		case caseToken.tokenType == CtkEOF:
+			return nil, parser.generateParseError(caseToken, "Unmatched \"{\": missing \"}\"")
+		case caseToken.tokenType != CtkWord:
+			return nil, parser.generateParseError(caseToken, "Expected select case value but got \"%v\"", caseToken.value)
+		}
+
+		arrowToken, err := parser.scan()
+		if err != nil {
+			return nil, err
+		} else if arrowToken.tokenType != CtkCaseArrow {
+			return nil, parser.generateParseError(arrowToken, "Expected \"=>\" but got \"%v\"", arrowToken.value)
+		}
+
+		branchCommandToken, err := parser.scan()
+		if err != nil {
+			return nil, err
+		} else if branchCommandToken.tokenType != CtkWord {
+			return nil, parser.generateParseError(branchCommandToken, "Expected a command but got \"%v\"", branchCommandToken.value)
+		}
+
+		command, blockEOF, err := parser.parseCommand(branchCommandToken)
+		if err != nil {
+			return nil, err
+		} else if blockEOF {
+			return nil, parser.generateParseError(branchCommandToken, "Unmatched \"{\": missing \"}\"")
+		}
+
+		selectCommand.branches = append(selectCommand.branches, &SelectBranch{
+			value:     caseToken,
+			isDefault: caseToken.value == "default",
+			command:   command,
+		})
+	}
+}
+
+// EvaluatePredicates reports whether every predicate in a when block holds
+// true for the provided context. Predicates are evaluated in order and
+// evaluation stops at the first one that fails.
+func (whenCommand *WhenCommand) EvaluatePredicates(context ConfigContext) (bool, error) {
+	return evaluatePredicates(whenCommand.predicates, context)
+}
+
+func evaluatePredicates(predicates []*ConfigToken, context ConfigContext) (bool, error) {
+	for i := 0; i < len(predicates); i++ {
+		predicateToken := predicates[i]
+		if predicateToken.tokenType != CtkOption {
+			return false, fmt.Errorf("Expected predicate but got \"%v\"", predicateToken.value)
+		}
+
+		var arg string
+		if i+1 < len(predicates) && predicates[i+1].tokenType != CtkOption {
+			i++
+			arg = predicates[i].value
+		}
+
+		matched, err := evaluatePredicate(predicateToken.value, arg, context)
+		if err != nil {
+			return false, err
+		} else if !matched {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func evaluatePredicate(predicate, arg string, context ConfigContext) (bool, error) {
+	switch predicate {
+	case "--os":
+		return context.OS() == arg, nil
+	case "--branch":
+		return context.CurrentBranch() == arg, nil
+	case "--repo-has-remote":
+		return context.HasRemote(arg), nil
+	case "--git-version":
+		return context.GitVersion() == arg, nil
+	default:
+		return false, fmt.Errorf("Unknown predicate \"%v\"", predicate)
+	}
+}
+
+// ResolveBranch returns the command selected by value, following the first
+// matching case or, if none match, the default branch
+func (selectCommand *SelectCommand) ResolveBranch(value string) (command ConfigCommand, matched bool) {
+	var defaultCommand ConfigCommand
+	var hasDefault bool
+
+	for _, branch := range selectCommand.branches {
+		if branch.isDefault {
+			defaultCommand, hasDefault = branch.command, true
+			continue
+		}
+
+		if branch.value.value == value {
+			return branch.command, true
+		}
+	}
+
+	return defaultCommand, hasDefault
+}
+
+func splitViewCommandConstructor(parser *ConfigParser, commandToken *ConfigToken, flags map[string]ConfigFlagValue, positional []*ConfigToken) (ConfigCommand, error) {
 	splitViewCommand := commandToken.value
 
-	if len(tokens) < 1 {
-		return nil, parser.generateParseError(commandToken, "Invalid %[1]v command. Usage: %[1]v [VIEW] [ARGS...]", splitViewCommand)
+	if len(positional) < 1 {
+		return nil, parser.generateParseError(commandToken, "Invalid %[1]v command. Usage: %[1]v [FLAGS...] [VIEW] [ARGS...]", splitViewCommand)
 	}
 
 	var orientation ContainerOrientation
@@ -417,9 +1208,22 @@ func splitViewCommandConstructor(parser *ConfigParser, commandToken *ConfigToken
 		return nil, parser.generateParseError(commandToken, "Unrecognised command: %v", splitViewCommand)
 	}
 
-	return &SplitViewCommand{
+	splitViewCommandValue := &SplitViewCommand{
 		orientation: orientation,
-		view:        tokens[0],
-		args:        tokens[1:],
-	}, nil
+		view:        positional[0],
+		args:        positional[1:],
+	}
+
+	if flagValue, ok := flags["--name"]; ok {
+		splitViewCommandValue.name = flagValue.valueToken
+	}
+	if flagValue, ok := flags["--ratio"]; ok {
+		splitViewCommandValue.ratio = flagValue.floatValue
+		splitViewCommandValue.hasRatio = true
+	}
+	if flagValue, ok := flags["--focus"]; ok {
+		splitViewCommandValue.focus = flagValue.boolValue
+	}
+
+	return splitViewCommandValue, nil
 }
@@ -0,0 +1,518 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfigFile(t *testing.T, dir, name, contents string) string {
+	path := filepath.Join(dir, name)
+	assert.NoError(t, os.WriteFile(path, []byte(contents), 0644))
+	return path
+}
+
+func parseAllCommands(t *testing.T, path string) ([]ConfigCommand, error) {
+	file, err := os.Open(path)
+	assert.NoError(t, err)
+	defer file.Close()
+
+	parser := NewConfigParser(file, path)
+
+	var commands []ConfigCommand
+	for {
+		command, eof, err := parser.Parse()
+		if err != nil {
+			return commands, err
+		}
+		if command != nil {
+			commands = append(commands, command)
+		}
+		if eof {
+			return commands, nil
+		}
+	}
+}
+
+type testConfigContext struct {
+	currentBranch string
+	os            string
+	remotes       map[string]struct{}
+	gitVersion    string
+}
+
+func (context *testConfigContext) CurrentBranch() string {
+	return context.currentBranch
+}
+
+func (context *testConfigContext) OS() string {
+	return context.os
+}
+
+func (context *testConfigContext) HasRemote(remote string) bool {
+	_, exists := context.remotes[remote]
+	return exists
+}
+
+func (context *testConfigContext) GitVersion() string {
+	return context.gitVersion
+}
+
+func optionToken(value string) *ConfigToken {
+	return &ConfigToken{tokenType: CtkOption, value: value}
+}
+
+func wordToken(value string) *ConfigToken {
+	return &ConfigToken{tokenType: CtkWord, value: value}
+}
+
+func TestEvaluatePredicatesReturnsTrueWhenAllPredicatesMatch(t *testing.T) {
+	context := &testConfigContext{os: "linux", remotes: map[string]struct{}{"origin": {}}}
+	predicates := []*ConfigToken{
+		optionToken("--os"), wordToken("linux"),
+		optionToken("--repo-has-remote"), wordToken("origin"),
+	}
+
+	matched, err := evaluatePredicates(predicates, context)
+
+	assert.NoError(t, err, "Valid predicates should not error")
+	assert.True(t, matched, "All predicates match so the block should be evaluated")
+}
+
+func TestEvaluatePredicatesReturnsFalseWhenAPredicateDoesNotMatch(t *testing.T) {
+	context := &testConfigContext{os: "darwin"}
+	predicates := []*ConfigToken{
+		optionToken("--os"), wordToken("linux"),
+	}
+
+	matched, err := evaluatePredicates(predicates, context)
+
+	assert.NoError(t, err, "A false predicate is a skip, not an error")
+	assert.False(t, matched, "Predicate does not match so the block should be skipped")
+}
+
+func TestEvaluatePredicatesStopsAtFirstFailingPredicate(t *testing.T) {
+	context := &testConfigContext{os: "darwin"}
+	predicates := []*ConfigToken{
+		optionToken("--os"), wordToken("linux"),
+		optionToken("--unknown-predicate-that-would-error"),
+	}
+
+	matched, err := evaluatePredicates(predicates, context)
+
+	assert.NoError(t, err, "Evaluation should short-circuit before reaching the unknown predicate")
+	assert.False(t, matched, "First predicate failed so the remainder must not be evaluated")
+}
+
+func TestEvaluatePredicatesReturnsErrorForUnknownPredicate(t *testing.T) {
+	context := &testConfigContext{}
+	predicates := []*ConfigToken{optionToken("--not-a-real-predicate")}
+
+	_, err := evaluatePredicates(predicates, context)
+
+	assert.Error(t, err, "Unknown predicates should be reported as an error")
+}
+
+func TestWhenCommandEvaluatePredicatesProxiesToHelper(t *testing.T) {
+	context := &testConfigContext{os: "linux"}
+	whenCommand := &WhenCommand{
+		predicates: []*ConfigToken{optionToken("--os"), wordToken("linux")},
+		commands:   []ConfigCommand{&QuitCommand{}},
+	}
+
+	matched, err := whenCommand.EvaluatePredicates(context)
+
+	assert.NoError(t, err)
+	assert.True(t, matched, "when block predicates should match")
+}
+
+func TestWhenCommandSupportsNestedCommands(t *testing.T) {
+	nestedWhen := &WhenCommand{
+		predicates: []*ConfigToken{optionToken("--os"), wordToken("linux")},
+		commands:   []ConfigCommand{&QuitCommand{}},
+	}
+
+	outerWhen := &WhenCommand{
+		predicates: []*ConfigToken{optionToken("--repo-has-remote"), wordToken("origin")},
+		commands:   []ConfigCommand{nestedWhen},
+	}
+
+	assert.Len(t, outerWhen.commands, 1, "Outer when block should contain one nested command")
+	assert.IsType(t, &WhenCommand{}, outerWhen.commands[0], "Nested command should itself be a when block")
+}
+
+func TestSelectCommandResolveBranchReturnsMatchingCase(t *testing.T) {
+	linuxCommand := &SetCommand{variable: wordToken("mouse"), value: wordToken("true")}
+	darwinCommand := &SetCommand{variable: wordToken("mouse"), value: wordToken("false")}
+
+	selectCommand := &SelectCommand{
+		on: wordToken("os"),
+		branches: []*SelectBranch{
+			{value: wordToken("linux"), command: linuxCommand},
+			{value: wordToken("darwin"), command: darwinCommand},
+		},
+	}
+
+	command, matched := selectCommand.ResolveBranch("darwin")
+
+	assert.True(t, matched, "darwin case should match")
+	assert.Equal(t, darwinCommand, command, "Resolved command should be the darwin branch")
+}
+
+func TestSelectCommandResolveBranchFallsBackToDefault(t *testing.T) {
+	defaultCommand := &QuitCommand{}
+
+	selectCommand := &SelectCommand{
+		on: wordToken("os"),
+		branches: []*SelectBranch{
+			{value: wordToken("linux"), command: &QuitCommand{}},
+			{isDefault: true, command: defaultCommand},
+		},
+	}
+
+	command, matched := selectCommand.ResolveBranch("windows")
+
+	assert.True(t, matched, "Unmatched case should fall back to default")
+	assert.Equal(t, defaultCommand, command, "Resolved command should be the default branch")
+}
+
+func TestSelectCommandResolveBranchReturnsNoMatchWithoutDefault(t *testing.T) {
+	selectCommand := &SelectCommand{
+		on: wordToken("os"),
+		branches: []*SelectBranch{
+			{value: wordToken("linux"), command: &QuitCommand{}},
+		},
+	}
+
+	command, matched := selectCommand.ResolveBranch("windows")
+
+	assert.False(t, matched, "No case matches and there is no default")
+	assert.Nil(t, command, "No command should be resolved")
+}
+
+func TestFindFlagSpecMatchesLongName(t *testing.T) {
+	flagSpecs := []commandFlagSpec{
+		{longName: "--name", flagType: CftString},
+		{longName: "--ratio", shortName: "-r", flagType: CftFloat},
+	}
+
+	flagSpec := findFlagSpec(flagSpecs, "--ratio")
+
+	assert.NotNil(t, flagSpec, "--ratio should be found by its long name")
+	assert.Equal(t, CftFloat, flagSpec.flagType)
+}
+
+func TestFindFlagSpecMatchesShortName(t *testing.T) {
+	flagSpecs := []commandFlagSpec{
+		{longName: "--ratio", shortName: "-r", flagType: CftFloat},
+	}
+
+	flagSpec := findFlagSpec(flagSpecs, "-r")
+
+	assert.NotNil(t, flagSpec, "-r should be found by its short name")
+}
+
+func TestFindFlagSpecReturnsNilForUnknownFlag(t *testing.T) {
+	flagSpecs := []commandFlagSpec{{longName: "--name", flagType: CftString}}
+
+	flagSpec := findFlagSpec(flagSpecs, "--bogus")
+
+	assert.Nil(t, flagSpec, "Unknown flag names should not resolve to a spec")
+}
+
+func TestIsValidEnumValue(t *testing.T) {
+	enumValues := []string{"linux", "darwin"}
+
+	assert.True(t, isValidEnumValue(enumValues, "linux"))
+	assert.False(t, isValidEnumValue(enumValues, "windows"))
+}
+
+// testFlagCaptureCommand exists only so flagSpec tests can inspect the
+// flags map parseFlaggedCommand builds, without having to route through a
+// real command's flagConstructor
+type testFlagCaptureCommand struct {
+	flags map[string]ConfigFlagValue
+}
+
+func (command *testFlagCaptureCommand) configCommand() {}
+
+func captureFlagsDescriptor(flags []commandFlagSpec) *commandDescriptor {
+	return &commandDescriptor{
+		flagged: true,
+		flags:   flags,
+		flagConstructor: func(parser *ConfigParser, commandToken *ConfigToken, flags map[string]ConfigFlagValue, positional []*ConfigToken) (ConfigCommand, error) {
+			return &testFlagCaptureCommand{flags: flags}, nil
+		},
+	}
+}
+
+func TestParseFlaggedCommandAppliesDefaultValueForOmittedFlag(t *testing.T) {
+	parser := NewConfigParser(strings.NewReader("\n"), "test")
+	descriptor := captureFlagsDescriptor([]commandFlagSpec{
+		{longName: "--name", flagType: CftString, defaultValue: "origin"},
+	})
+
+	command, _, err := parser.parseFlaggedCommand(descriptor, wordToken("testcmd"))
+
+	assert.NoError(t, err)
+	flagValue, ok := command.(*testFlagCaptureCommand).flags["--name"]
+	if assert.True(t, ok, "Omitted flag with a default should still be present") {
+		assert.Equal(t, "origin", flagValue.strValue)
+	}
+}
+
+func TestParseFlaggedCommandReturnsErrorForDuplicateNonRepeatedFlag(t *testing.T) {
+	parser := NewConfigParser(strings.NewReader("--name foo --name bar\n"), "test")
+	descriptor := captureFlagsDescriptor([]commandFlagSpec{
+		{longName: "--name", flagType: CftString},
+	})
+
+	_, _, err := parser.parseFlaggedCommand(descriptor, wordToken("testcmd"))
+
+	if assert.Error(t, err, "A flag given twice without repeated set should be an error") {
+		assert.Contains(t, err.Error(), "more than once")
+	}
+}
+
+func TestParseFlaggedCommandCollectsRepeatedFlagOccurrences(t *testing.T) {
+	parser := NewConfigParser(strings.NewReader("--name foo --name bar\n"), "test")
+	descriptor := captureFlagsDescriptor([]commandFlagSpec{
+		{longName: "--name", flagType: CftString, repeated: true},
+	})
+
+	command, _, err := parser.parseFlaggedCommand(descriptor, wordToken("testcmd"))
+
+	assert.NoError(t, err)
+	flagValue := command.(*testFlagCaptureCommand).flags["--name"]
+	assert.Equal(t, "foo", flagValue.strValue, "First occurrence should be the primary value")
+	if assert.Len(t, flagValue.repeats, 1, "Second occurrence should be collected in repeats") {
+		assert.Equal(t, "bar", flagValue.repeats[0].strValue)
+	}
+}
+
+func TestSetCommandAcceptsAnExpressionValueFromTheRealParser(t *testing.T) {
+	dir := t.TempDir()
+	root := writeConfigFile(t, dir, "root.grvrc", "set total $(2 * 3)\n")
+
+	commands, err := parseAllCommands(t, root)
+
+	if assert.NoError(t, err) && assert.Len(t, commands, 1) {
+		setCommand := commands[0].(*SetCommand)
+		assert.Equal(t, CtkExpression, setCommand.value.tokenType)
+	}
+}
+
+func TestSetCommandRejectsAnExpressionForTheVariableSlot(t *testing.T) {
+	dir := t.TempDir()
+	root := writeConfigFile(t, dir, "root.grvrc", "set $(1 + 1) x\n")
+
+	_, err := parseAllCommands(t, root)
+
+	assert.Error(t, err, "An expression is only valid in a set command's value position, not its variable name")
+}
+
+func TestMapCommandRejectsExpressionTokensForItsPlainWordArguments(t *testing.T) {
+	dir := t.TempDir()
+	root := writeConfigFile(t, dir, "root.grvrc", "map normal $(foo) someaction\n")
+
+	_, err := parseAllCommands(t, root)
+
+	assert.Error(t, err, "map never resolves expressions, so $(...) should not be accepted for any of its arguments")
+}
+
+func TestAddTabCommandRejectsAnExpressionTabName(t *testing.T) {
+	dir := t.TempDir()
+	root := writeConfigFile(t, dir, "root.grvrc", "addtab $(foo)\n")
+
+	_, err := parseAllCommands(t, root)
+
+	assert.Error(t, err, "addtab never resolves expressions, so $(...) should not be accepted for its tab name")
+}
+
+func TestSourceCommandInlinesCommandsFromTheReferencedFile(t *testing.T) {
+	dir := t.TempDir()
+	writeConfigFile(t, dir, "included.grvrc", "set b 2\n")
+	root := writeConfigFile(t, dir, "root.grvrc", "set a 1\nsource included.grvrc\nset c 3\n")
+
+	commands, err := parseAllCommands(t, root)
+
+	assert.NoError(t, err)
+	if assert.Len(t, commands, 3, "source should splice in the included file's commands") {
+		assert.Equal(t, "a", commands[0].(*SetCommand).variable.value)
+		assert.Equal(t, "b", commands[1].(*SetCommand).variable.value)
+		assert.Equal(t, "c", commands[2].(*SetCommand).variable.value)
+	}
+}
+
+func TestSourceCommandSupportsDeepNesting(t *testing.T) {
+	dir := t.TempDir()
+	writeConfigFile(t, dir, "c.grvrc", "set c 3\n")
+	writeConfigFile(t, dir, "b.grvrc", "set b 2\nsource c.grvrc\n")
+	root := writeConfigFile(t, dir, "a.grvrc", "set a 1\nsource b.grvrc\n")
+
+	commands, err := parseAllCommands(t, root)
+
+	assert.NoError(t, err)
+	if assert.Len(t, commands, 3, "nested source commands should all be inlined") {
+		assert.Equal(t, "a", commands[0].(*SetCommand).variable.value)
+		assert.Equal(t, "b", commands[1].(*SetCommand).variable.value)
+		assert.Equal(t, "c", commands[2].(*SetCommand).variable.value)
+	}
+}
+
+func TestSourceCommandReturnsErrorForMissingFile(t *testing.T) {
+	dir := t.TempDir()
+	root := writeConfigFile(t, dir, "root.grvrc", "source does-not-exist.grvrc\n")
+
+	_, err := parseAllCommands(t, root)
+
+	assert.Error(t, err, "Sourcing a missing file should be an error")
+}
+
+func TestSourceCommandDetectsCycles(t *testing.T) {
+	dir := t.TempDir()
+	writeConfigFile(t, dir, "b.grvrc", "source a.grvrc\n")
+	root := writeConfigFile(t, dir, "a.grvrc", "source b.grvrc\n")
+
+	_, err := parseAllCommands(t, root)
+
+	if assert.Error(t, err, "A source cycle should be detected and reported") {
+		assert.Contains(t, err.Error(), "source cycle:")
+		assert.Contains(t, err.Error(), "a.grvrc")
+		assert.Contains(t, err.Error(), "b.grvrc")
+	}
+}
+
+func TestSourceCommandSurfacesSyntaxErrorsWithTheIncludedFileName(t *testing.T) {
+	dir := t.TempDir()
+	writeConfigFile(t, dir, "broken.grvrc", "notacommand foo\n")
+	root := writeConfigFile(t, dir, "root.grvrc", "source broken.grvrc\n")
+
+	_, err := parseAllCommands(t, root)
+
+	if assert.Error(t, err, "A syntax error in a sourced file should be reported") {
+		assert.True(t, strings.Contains(err.Error(), "broken.grvrc"),
+			"Error should reference the sourced file, not just the root file: %v", err)
+	}
+}
+
+func TestWhenCommandParsesWithBlockCloseAndOpenOnSeparateLines(t *testing.T) {
+	dir := t.TempDir()
+	root := writeConfigFile(t, dir, "root.grvrc", "when {\n  --os linux\n} {\n  set mouse true\n}\n")
+
+	commands, err := parseAllCommands(t, root)
+
+	if assert.NoError(t, err) && assert.Len(t, commands, 1) {
+		whenCommand := commands[0].(*WhenCommand)
+		assert.Len(t, whenCommand.commands, 1, "when body should contain the nested set command")
+	}
+}
+
+func TestWhenCommandSupportsNestedWhenBlocksFromTheRealParser(t *testing.T) {
+	dir := t.TempDir()
+	root := writeConfigFile(t, dir, "root.grvrc", "when { --os linux } {\n  when { --branch master } {\n    set mouse true\n  }\n}\n")
+
+	commands, err := parseAllCommands(t, root)
+
+	if assert.NoError(t, err) && assert.Len(t, commands, 1) {
+		outerWhen := commands[0].(*WhenCommand)
+		if assert.Len(t, outerWhen.commands, 1) {
+			assert.IsType(t, &WhenCommand{}, outerWhen.commands[0], "Nested command should itself be a when block")
+		}
+	}
+}
+
+func TestWhenCommandReturnsErrorForUnmatchedPredicateBlock(t *testing.T) {
+	dir := t.TempDir()
+	root := writeConfigFile(t, dir, "root.grvrc", "when {\n  --os linux\n")
+
+	_, err := parseAllCommands(t, root)
+
+	if assert.Error(t, err, "A missing predicate block close should be reported") {
+		assert.Contains(t, err.Error(), "Unmatched")
+	}
+}
+
+func TestWhenCommandReturnsErrorForUnmatchedBodyBlock(t *testing.T) {
+	dir := t.TempDir()
+	root := writeConfigFile(t, dir, "root.grvrc", "when { --os linux } {\n  set mouse true\n")
+
+	_, err := parseAllCommands(t, root)
+
+	if assert.Error(t, err, "A missing body block close should be reported") {
+		assert.Contains(t, err.Error(), "Unmatched")
+	}
+}
+
+func TestWhenCommandFromTheRealParserCanFailItsPredicates(t *testing.T) {
+	dir := t.TempDir()
+	root := writeConfigFile(t, dir, "root.grvrc", "when { --os linux } {\n  set mouse true\n}\n")
+
+	commands, err := parseAllCommands(t, root)
+	assert.NoError(t, err)
+	whenCommand := commands[0].(*WhenCommand)
+
+	matched, err := whenCommand.EvaluatePredicates(&testConfigContext{os: "darwin"})
+
+	assert.NoError(t, err)
+	assert.False(t, matched, "when block parsed from config should be skipped when its predicate fails")
+}
+
+func TestSelectCommandParsesBranchesFromTheRealParser(t *testing.T) {
+	dir := t.TempDir()
+	root := writeConfigFile(t, dir, "root.grvrc", "select --on os {\n  linux => set mouse true\n  default => set mouse false\n}\n")
+
+	commands, err := parseAllCommands(t, root)
+
+	if assert.NoError(t, err) && assert.Len(t, commands, 1) {
+		selectCommand := commands[0].(*SelectCommand)
+		command, matched := selectCommand.ResolveBranch("linux")
+		assert.True(t, matched)
+		assert.Equal(t, "true", command.(*SetCommand).value.value)
+	}
+}
+
+func TestSelectCommandReturnsErrorForUnmatchedBlock(t *testing.T) {
+	dir := t.TempDir()
+	root := writeConfigFile(t, dir, "root.grvrc", "select --on os {\n  linux => set mouse true\n")
+
+	_, err := parseAllCommands(t, root)
+
+	if assert.Error(t, err, "A missing select block close should be reported") {
+		assert.Contains(t, err.Error(), "Unmatched")
+	}
+}
+
+func TestParseClosesSourcedChildFileOnNonEOFError(t *testing.T) {
+	dir := t.TempDir()
+	path := writeConfigFile(t, dir, "broken.grvrc", "notacommand foo\n")
+
+	file, err := os.Open(path)
+	assert.NoError(t, err)
+
+	child := newSourcedConfigParser(file, path, nil)
+	parser := &ConfigParser{activeChild: child}
+
+	_, _, err = parser.Parse()
+	assert.Error(t, err, "An invalid command in the sourced file should be a parse error")
+
+	_, readErr := file.Read(make([]byte, 1))
+	assert.Error(t, readErr, "The child's file should already be closed once its non-EOF error has propagated")
+}
+
+func TestSourceCommandExpandsGlobs(t *testing.T) {
+	dir := t.TempDir()
+	pluginDir := filepath.Join(dir, "plugins")
+	assert.NoError(t, os.Mkdir(pluginDir, 0755))
+	writeConfigFile(t, pluginDir, "one.grvrc", "set one 1\n")
+	writeConfigFile(t, pluginDir, "two.grvrc", "set two 2\n")
+	root := writeConfigFile(t, dir, "root.grvrc", "source plugins/*.grvrc\n")
+
+	commands, err := parseAllCommands(t, root)
+
+	assert.NoError(t, err)
+	assert.Len(t, commands, 2, "Both globbed files should contribute their commands")
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// ConfigTokenType identifies the kind of token produced by ConfigScanner
+type ConfigTokenType int
+
+// The base set of token types the scanner produces. Token types used to
+// delimit a conditional command block (CtkBlockOpen, CtkBlockClose,
+// CtkCaseArrow) and a $(...) expression value (CtkExpression) are declared
+// alongside the command types that consume them, in config_parse.go.
+const (
+	// CtkInvalid represents a token the scanner could not make sense of,
+	// e.g. an unterminated quoted word or an unterminated $(...) expression
+	CtkInvalid ConfigTokenType = iota
+	// CtkWord represents a plain, unquoted or quoted, config word
+	CtkWord
+	// CtkTerminator represents the end of a command: a newline or ';'
+	CtkTerminator
+	// CtkOption represents a "--name" style flag
+	CtkOption
+	// CtkWhiteSpace represents a run of spaces or tabs
+	CtkWhiteSpace
+	// CtkComment represents a '#' comment running to the end of the line
+	CtkComment
+	// CtkEOF represents the end of the input stream
+	CtkEOF
+)
+
+// ConfigTokenPos describes the position a token started at, for error
+// messages
+type ConfigTokenPos struct {
+	line uint
+	col  uint
+}
+
+// ConfigToken is a single lexical token produced by ConfigScanner
+type ConfigToken struct {
+	tokenType ConfigTokenType
+	value     string
+	startPos  ConfigTokenPos
+	err       error
+}
+
+// ConfigTokenName returns the human readable name of a token type, for use
+// in parse error messages
+func ConfigTokenName(tokenType ConfigTokenType) string {
+	switch tokenType {
+	case CtkInvalid:
+		return "Invalid"
+	case CtkWord:
+		return "Word"
+	case CtkTerminator:
+		return "Terminator"
+	case CtkOption:
+		return "Option"
+	case CtkWhiteSpace:
+		return "WhiteSpace"
+	case CtkComment:
+		return "Comment"
+	case CtkEOF:
+		return "EOF"
+	case CtkBlockOpen:
+		return "BlockOpen"
+	case CtkBlockClose:
+		return "BlockClose"
+	case CtkCaseArrow:
+		return "CaseArrow"
+	case CtkExpression:
+		return "Expression"
+	default:
+		return "Unknown"
+	}
+}
+
+// ConfigScanner lexes config file input into a stream of ConfigTokens
+type ConfigScanner struct {
+	reader *bufio.Reader
+	line   uint
+	col    uint
+
+	// position before the most recent readRune, restored by unreadRune
+	prevLine, prevCol uint
+}
+
+// NewConfigScanner creates a new ConfigScanner which reads from reader
+func NewConfigScanner(reader io.Reader) *ConfigScanner {
+	return &ConfigScanner{
+		reader: bufio.NewReader(reader),
+		line:   1,
+		col:    1,
+	}
+}
+
+func (scanner *ConfigScanner) readRune() (rune, error) {
+	r, _, err := scanner.reader.ReadRune()
+	if err != nil {
+		return 0, err
+	}
+
+	scanner.prevLine, scanner.prevCol = scanner.line, scanner.col
+
+	if r == '\n' {
+		scanner.line++
+		scanner.col = 1
+	} else {
+		scanner.col++
+	}
+
+	return r, nil
+}
+
+func (scanner *ConfigScanner) unreadRune() {
+	scanner.reader.UnreadRune()
+	scanner.line, scanner.col = scanner.prevLine, scanner.prevCol
+}
+
+func (scanner *ConfigScanner) peekRune() (rune, error) {
+	r, _, err := scanner.reader.ReadRune()
+	if err != nil {
+		return 0, err
+	}
+
+	scanner.reader.UnreadRune()
+
+	return r, nil
+}
+
+func isConfigWhiteSpace(r rune) bool {
+	return r == ' ' || r == '\t'
+}
+
+func isConfigWordBoundary(r rune) bool {
+	switch r {
+	case ' ', '\t', '\n', ';', '{', '}', '#':
+		return true
+	default:
+		return false
+	}
+}
+
+// Scan returns the next token from the input stream. A non-nil error is
+// only returned for an underlying read failure other than io.EOF; a
+// malformed token (e.g. an unterminated quote) is instead returned as a
+// CtkInvalid token with its err field set, so the caller can report a parse
+// error at the token's position.
+func (scanner *ConfigScanner) Scan() (*ConfigToken, error) {
+	startPos := ConfigTokenPos{line: scanner.line, col: scanner.col}
+
+	r, err := scanner.readRune()
+	if err == io.EOF {
+		return &ConfigToken{tokenType: CtkEOF, startPos: startPos}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case isConfigWhiteSpace(r):
+		return scanner.scanWhiteSpace(r, startPos), nil
+	case r == '\n' || r == ';':
+		return &ConfigToken{tokenType: CtkTerminator, value: string(r), startPos: startPos}, nil
+	case r == '#':
+		return scanner.scanComment(startPos), nil
+	case r == '{':
+		return &ConfigToken{tokenType: CtkBlockOpen, value: "{", startPos: startPos}, nil
+	case r == '}':
+		return &ConfigToken{tokenType: CtkBlockClose, value: "}", startPos: startPos}, nil
+	case r == '"':
+		return scanner.scanQuotedWord(startPos), nil
+	case r == '$':
+		if next, peekErr := scanner.peekRune(); peekErr == nil && next == '(' {
+			scanner.readRune()
+			return scanner.scanExpression(startPos), nil
+		}
+
+		return scanner.scanWord(r, startPos), nil
+	case r == '-':
+		if next, peekErr := scanner.peekRune(); peekErr == nil && next == '-' {
+			scanner.readRune()
+			return scanner.scanOption(startPos), nil
+		}
+
+		return scanner.scanWord(r, startPos), nil
+	case r == '=':
+		if next, peekErr := scanner.peekRune(); peekErr == nil && next == '>' {
+			scanner.readRune()
+			return &ConfigToken{tokenType: CtkCaseArrow, value: "=>", startPos: startPos}, nil
+		}
+
+		return scanner.scanWord(r, startPos), nil
+	default:
+		return scanner.scanWord(r, startPos), nil
+	}
+}
+
+func (scanner *ConfigScanner) scanWhiteSpace(first rune, startPos ConfigTokenPos) *ConfigToken {
+	value := []rune{first}
+
+	for {
+		r, err := scanner.readRune()
+		if err != nil {
+			break
+		} else if !isConfigWhiteSpace(r) {
+			scanner.unreadRune()
+			break
+		}
+
+		value = append(value, r)
+	}
+
+	return &ConfigToken{tokenType: CtkWhiteSpace, value: string(value), startPos: startPos}
+}
+
+func (scanner *ConfigScanner) scanComment(startPos ConfigTokenPos) *ConfigToken {
+	var value []rune
+
+	for {
+		r, err := scanner.readRune()
+		if err != nil || r == '\n' {
+			if err == nil {
+				scanner.unreadRune()
+			}
+			break
+		}
+
+		value = append(value, r)
+	}
+
+	return &ConfigToken{tokenType: CtkComment, value: string(value), startPos: startPos}
+}
+
+func (scanner *ConfigScanner) scanWord(first rune, startPos ConfigTokenPos) *ConfigToken {
+	value := []rune{first}
+
+	for {
+		r, err := scanner.readRune()
+		if err != nil {
+			break
+		} else if isConfigWordBoundary(r) {
+			scanner.unreadRune()
+			break
+		}
+
+		value = append(value, r)
+	}
+
+	return &ConfigToken{tokenType: CtkWord, value: string(value), startPos: startPos}
+}
+
+func (scanner *ConfigScanner) scanOption(startPos ConfigTokenPos) *ConfigToken {
+	value := []rune{'-', '-'}
+
+	for {
+		r, err := scanner.readRune()
+		if err != nil {
+			break
+		} else if isConfigWordBoundary(r) {
+			scanner.unreadRune()
+			break
+		}
+
+		value = append(value, r)
+	}
+
+	return &ConfigToken{tokenType: CtkOption, value: string(value), startPos: startPos}
+}
+
+func (scanner *ConfigScanner) scanQuotedWord(startPos ConfigTokenPos) *ConfigToken {
+	var value []rune
+
+	for {
+		r, err := scanner.readRune()
+		if err != nil {
+			return &ConfigToken{
+				tokenType: CtkInvalid, value: string(value), startPos: startPos,
+				err: fmt.Errorf("Unterminated quoted word"),
+			}
+		}
+
+		switch r {
+		case '"':
+			return &ConfigToken{tokenType: CtkWord, value: string(value), startPos: startPos}
+		case '\\':
+			escaped, escErr := scanner.readRune()
+			if escErr != nil {
+				return &ConfigToken{
+					tokenType: CtkInvalid, value: string(value), startPos: startPos,
+					err: fmt.Errorf("Unterminated quoted word"),
+				}
+			}
+			value = append(value, escaped)
+		default:
+			value = append(value, r)
+		}
+	}
+}
+
+// scanExpression reads the raw text of a $(...) expression, tracking
+// nested parens so an expression may itself contain parenthesised
+// sub-expressions. The scanner has already consumed "$(" by the time this
+// is called; the token's value is the text up to, but excluding, the
+// matching close paren. Evaluating that text is config_expr.go's job.
+func (scanner *ConfigScanner) scanExpression(startPos ConfigTokenPos) *ConfigToken {
+	var value []rune
+	depth := 1
+
+	for {
+		r, err := scanner.readRune()
+		if err != nil {
+			return &ConfigToken{
+				tokenType: CtkInvalid, value: string(value), startPos: startPos,
+				err: fmt.Errorf("Unterminated expression: missing \")\""),
+			}
+		}
+
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return &ConfigToken{tokenType: CtkExpression, value: string(value), startPos: startPos}
+			}
+		}
+
+		value = append(value, r)
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Multi-selection actions supported by SelectableRowView. Declared here,
+// offset well above the core action set defined elsewhere, to avoid
+// colliding with the values assigned in the main action table.
+const (
+	ActionToggleSelection ActionType = iota + 10000
+	ActionSelectRange
+	ActionClearSelection
+	ActionSelectAll
+)
+
+// selectableRowChildWindowView is the interface a child view must embed in
+// order to have SelectableRowView manage which of its rows is active and
+// which are part of the current multi-selection
+type selectableRowChildWindowView interface {
+	childWindowView
+	isSelectableRow(rowIndex uint) bool
+	onRowSelected(rowIndex uint) error
+	onSelectionChanged(selectedRows []uint) error
+}
+
+// selectableRowDecorator proxies childWindowView/selectableRowChildWindowView
+// calls through to a decorated child without itself being notified when the
+// active row or selection changes. Embedding this lets a view opt into
+// SelectableRowView support without implementing onRowSelected/
+// onSelectionChanged itself.
+type selectableRowDecorator struct {
+	child selectableRowChildWindowView
+}
+
+func newSelectableRowDecorator(child selectableRowChildWindowView) *selectableRowDecorator {
+	return &selectableRowDecorator{child: child}
+}
+
+func (selectableRowDecorator *selectableRowDecorator) viewPos() ViewPos {
+	return selectableRowDecorator.child.viewPos()
+}
+
+func (selectableRowDecorator *selectableRowDecorator) rows() uint {
+	return selectableRowDecorator.child.rows()
+}
+
+func (selectableRowDecorator *selectableRowDecorator) viewDimension() ViewDimension {
+	return selectableRowDecorator.child.viewDimension()
+}
+
+func (selectableRowDecorator *selectableRowDecorator) isSelectableRow(rowIndex uint) bool {
+	return selectableRowDecorator.child.isSelectableRow(rowIndex)
+}
+
+func (selectableRowDecorator *selectableRowDecorator) onRowSelected(rowIndex uint) error {
+	return nil
+}
+
+func (selectableRowDecorator *selectableRowDecorator) onSelectionChanged(selectedRows []uint) error {
+	return nil
+}
+
+func (selectableRowDecorator *selectableRowDecorator) notifyChildRowSelected(rowIndex uint) error {
+	return selectableRowDecorator.child.onRowSelected(rowIndex)
+}
+
+func (selectableRowDecorator *selectableRowDecorator) notifyChildSelectionChanged(selectedRows []uint) error {
+	return selectableRowDecorator.child.onSelectionChanged(selectedRows)
+}
+
+// SelectableRowView manages which row of a child view is active, moving the
+// active row in response to actions and keeping it in sync with the child's
+// viewport. It also tracks an optional multi-row selection on top of the
+// single active row, either toggled row by row or marked out as a
+// visual-mode style range anchored at the row active when the range began.
+type SelectableRowView struct {
+	child           selectableRowChildWindowView
+	channels        Channels
+	config          Config
+	description     string
+	selectedRows    map[uint]struct{}
+	selectionAnchor *uint
+}
+
+// NewSelectableRowView creates a new instance
+func NewSelectableRowView(child selectableRowChildWindowView, channels Channels, config Config, description string) *SelectableRowView {
+	return &SelectableRowView{
+		child:        child,
+		channels:     channels,
+		config:       config,
+		description:  description,
+		selectedRows: make(map[uint]struct{}),
+	}
+}
+
+// HandleAction checks if SelectableRowView can handle the action provided
+func (selectableRowView *SelectableRowView) HandleAction(action Action) (handled bool, err error) {
+	viewPos := selectableRowView.child.viewPos()
+	startActiveRowIndex := viewPos.ActiveRowIndex()
+
+	switch action.ActionType {
+	case ActionPrevLine, ActionNextLine:
+		handled = true
+		err = selectableRowView.handleLineMovement(viewPos, action)
+	case ActionMouseSelect:
+		handled = true
+		err = selectableRowView.handleMouseSelect(action)
+	case ActionToggleSelection:
+		handled = true
+		err = selectableRowView.toggleSelection(startActiveRowIndex)
+	case ActionSelectRange:
+		handled = true
+		err = selectableRowView.extendSelectionRange(startActiveRowIndex)
+	case ActionClearSelection:
+		handled = true
+		err = selectableRowView.clearSelection()
+	case ActionSelectAll:
+		handled = true
+		err = selectableRowView.selectAll()
+	}
+
+	return
+}
+
+func (selectableRowView *SelectableRowView) handleLineMovement(viewPos ViewPos, action Action) error {
+	previousActiveRowIndex := viewPos.ActiveRowIndex()
+
+	var moved bool
+	switch action.ActionType {
+	case ActionPrevLine:
+		moved = viewPos.MoveLineUp()
+	case ActionNextLine:
+		moved = viewPos.MoveLineDown(selectableRowView.child.rows())
+	}
+
+	if !moved {
+		return nil
+	}
+
+	newActiveRowIndex := viewPos.ActiveRowIndex()
+	if newActiveRowIndex == previousActiveRowIndex {
+		return nil
+	}
+
+	return selectableRowView.notifySelectableRow(viewPos, action.ActionType, newActiveRowIndex)
+}
+
+func (selectableRowView *SelectableRowView) notifySelectableRow(viewPos ViewPos, actionType ActionType, newActiveRowIndex uint) error {
+	rows := selectableRowView.child.rows()
+	descending := actionType == ActionPrevLine
+
+	selectedRowIndex, found := selectableRowView.findSelectableRow(newActiveRowIndex, rows, descending)
+	if !found {
+		return nil
+	}
+
+	if selectedRowIndex != newActiveRowIndex {
+		viewPos.SetActiveRowIndex(selectedRowIndex)
+	}
+
+	if selectableRowView.selectionAnchor != nil {
+		if err := selectableRowView.applySelectionRange(selectedRowIndex); err != nil {
+			return err
+		}
+	}
+
+	return selectableRowView.child.onRowSelected(selectedRowIndex)
+}
+
+// findSelectableRow looks for a selectable row starting at start and moving
+// in the direction the active row just moved. If none is found that way it
+// falls back to searching the opposite direction from start, so a move that
+// runs off the end of the view still lands on the nearest selectable row.
+func (selectableRowView *SelectableRowView) findSelectableRow(start, rows uint, descending bool) (uint, bool) {
+	if rowIndex, found := selectableRowView.searchSelectableRow(start, rows, descending); found {
+		return rowIndex, true
+	}
+
+	return selectableRowView.searchSelectableRow(start, rows, !descending)
+}
+
+func (selectableRowView *SelectableRowView) searchSelectableRow(start, rows uint, descending bool) (uint, bool) {
+	rowIndex := start
+
+	for {
+		if selectableRowView.child.isSelectableRow(rowIndex) {
+			return rowIndex, true
+		}
+
+		if descending {
+			if rowIndex == 0 {
+				return 0, false
+			}
+			rowIndex--
+		} else {
+			if rowIndex+1 >= rows {
+				return 0, false
+			}
+			rowIndex++
+		}
+	}
+}
+
+func (selectableRowView *SelectableRowView) handleMouseSelect(action Action) error {
+	if len(action.Args) == 0 {
+		return fmt.Errorf("Expected a mouse event argument for ActionMouseSelect")
+	}
+
+	return nil
+}
+
+// toggleSelection flips whether rowIndex is part of the current
+// multi-selection
+func (selectableRowView *SelectableRowView) toggleSelection(rowIndex uint) error {
+	if !selectableRowView.child.isSelectableRow(rowIndex) {
+		return nil
+	}
+
+	if _, selected := selectableRowView.selectedRows[rowIndex]; selected {
+		delete(selectableRowView.selectedRows, rowIndex)
+	} else {
+		selectableRowView.selectedRows[rowIndex] = struct{}{}
+	}
+
+	return selectableRowView.notifySelectionChanged()
+}
+
+// extendSelectionRange marks rowIndex as the anchor of a new visual-mode
+// selection range if one isn't already in progress, then (re)applies the
+// range between the anchor and rowIndex
+func (selectableRowView *SelectableRowView) extendSelectionRange(rowIndex uint) error {
+	if selectableRowView.selectionAnchor == nil {
+		anchor := rowIndex
+		selectableRowView.selectionAnchor = &anchor
+	}
+
+	return selectableRowView.applySelectionRange(rowIndex)
+}
+
+// applySelectionRange selects every selectable row between the anchor and
+// activeRowIndex inclusive. Rows in between that aren't selectable are
+// skipped, but don't otherwise affect where the range starts or ends.
+func (selectableRowView *SelectableRowView) applySelectionRange(activeRowIndex uint) error {
+	start, end := *selectableRowView.selectionAnchor, activeRowIndex
+	if start > end {
+		start, end = end, start
+	}
+
+	selectedRows := make(map[uint]struct{})
+	for rowIndex := start; rowIndex <= end; rowIndex++ {
+		if selectableRowView.child.isSelectableRow(rowIndex) {
+			selectedRows[rowIndex] = struct{}{}
+		}
+	}
+
+	selectableRowView.selectedRows = selectedRows
+
+	return selectableRowView.notifySelectionChanged()
+}
+
+// clearSelection discards the current multi-selection and any in-progress
+// visual-mode range
+func (selectableRowView *SelectableRowView) clearSelection() error {
+	selectableRowView.selectedRows = make(map[uint]struct{})
+	selectableRowView.selectionAnchor = nil
+
+	return selectableRowView.notifySelectionChanged()
+}
+
+// selectAll selects every selectable row in the child view
+func (selectableRowView *SelectableRowView) selectAll() error {
+	rows := selectableRowView.child.rows()
+	selectedRows := make(map[uint]struct{}, rows)
+
+	for rowIndex := uint(0); rowIndex < rows; rowIndex++ {
+		if selectableRowView.child.isSelectableRow(rowIndex) {
+			selectedRows[rowIndex] = struct{}{}
+		}
+	}
+
+	selectableRowView.selectedRows = selectedRows
+
+	return selectableRowView.notifySelectionChanged()
+}
+
+// SelectedRows returns the currently selected row indices in ascending order
+func (selectableRowView *SelectableRowView) SelectedRows() []uint {
+	selectedRows := make([]uint, 0, len(selectableRowView.selectedRows))
+	for rowIndex := range selectableRowView.selectedRows {
+		selectedRows = append(selectedRows, rowIndex)
+	}
+
+	sort.Slice(selectedRows, func(i, j int) bool { return selectedRows[i] < selectedRows[j] })
+
+	return selectedRows
+}
+
+func (selectableRowView *SelectableRowView) notifySelectionChanged() error {
+	err := selectableRowView.child.onSelectionChanged(selectableRowView.SelectedRows())
+	selectableRowView.channels.UpdateDisplay()
+	return err
+}
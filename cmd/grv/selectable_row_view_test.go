@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 )
 
 type MockSelectableRowChildWindowView struct {
@@ -16,6 +17,11 @@ func (selectableRowChildWindowView *MockSelectableRowChildWindowView) isSelectab
 	return args.Bool(0)
 }
 
+func (selectableRowChildWindowView *MockSelectableRowChildWindowView) onSelectionChanged(selectedRows []uint) error {
+	args := selectableRowChildWindowView.Called(selectedRows)
+	return args.Error(0)
+}
+
 func setupSelectableRowDecorator() (*selectableRowDecorator, *MockSelectableRowChildWindowView) {
 	child := &MockSelectableRowChildWindowView{}
 	return newSelectableRowDecorator(child), child
@@ -205,3 +211,153 @@ func TestWhenActiveRowIndexDoesChangeDownwardsAndRowIsNotSelectableThenNextSelec
 	mocks.viewPos.AssertCalled(t, "SetActiveRowIndex", uint(97))
 	mocks.child.AssertCalled(t, "onRowSelected", uint(97))
 }
+
+func TestActionToggleSelectionAddsAndRemovesTheActiveRowFromTheSelection(t *testing.T) {
+	selectableRowView, mocks := setupSelectableRowView()
+	mocks.viewPos.On("ActiveRowIndex").Return(uint(5))
+	mocks.child.On("isSelectableRow", uint(5)).Return(true)
+	mocks.child.On("onSelectionChanged", []uint{uint(5)}).Return(nil)
+
+	handled, err := selectableRowView.HandleAction(Action{ActionType: ActionToggleSelection})
+
+	assert.True(t, handled, "ActionToggleSelection should be handled")
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{5}, selectableRowView.SelectedRows())
+	mocks.child.AssertCalled(t, "onSelectionChanged", []uint{uint(5)})
+
+	mocks.child.On("onSelectionChanged", []uint{}).Return(nil)
+	selectableRowView.HandleAction(Action{ActionType: ActionToggleSelection})
+
+	assert.Equal(t, []uint{}, selectableRowView.SelectedRows(), "Toggling a selected row again should remove it")
+}
+
+func TestActionToggleSelectionIgnoresNonSelectableRows(t *testing.T) {
+	selectableRowView, mocks := setupSelectableRowView()
+	mocks.viewPos.On("ActiveRowIndex").Return(uint(5))
+	mocks.child.On("isSelectableRow", uint(5)).Return(false)
+
+	selectableRowView.HandleAction(Action{ActionType: ActionToggleSelection})
+
+	assert.Equal(t, []uint{}, selectableRowView.SelectedRows())
+	mocks.child.AssertNotCalled(t, "onSelectionChanged", mock.Anything)
+}
+
+func TestActionSelectRangeExpandsDownwardsFromTheAnchor(t *testing.T) {
+	selectableRowView, mocks := setupSelectableRowView()
+	mocks.viewPos.On("ActiveRowIndex").Return(uint(2)).Once()
+	mocks.child.On("isSelectableRow", uint(2)).Return(true)
+	mocks.child.On("onSelectionChanged", []uint{uint(2)}).Return(nil)
+
+	selectableRowView.HandleAction(Action{ActionType: ActionSelectRange})
+	assert.Equal(t, []uint{2}, selectableRowView.SelectedRows(), "Starting a range selects just the anchor row")
+
+	mocks.viewPos.On("ActiveRowIndex").Return(uint(4)).Once()
+	mocks.child.On("isSelectableRow", uint(3)).Return(true)
+	mocks.child.On("isSelectableRow", uint(4)).Return(true)
+	mocks.child.On("onSelectionChanged", []uint{uint(2), uint(3), uint(4)}).Return(nil)
+
+	selectableRowView.HandleAction(Action{ActionType: ActionSelectRange})
+
+	assert.Equal(t, []uint{2, 3, 4}, selectableRowView.SelectedRows(), "Range should expand to include every row between the anchor and the active row")
+}
+
+func TestActionSelectRangeExpandsUpwardsFromTheAnchor(t *testing.T) {
+	selectableRowView, mocks := setupSelectableRowView()
+	mocks.viewPos.On("ActiveRowIndex").Return(uint(8)).Once()
+	mocks.child.On("isSelectableRow", uint(8)).Return(true)
+	mocks.child.On("onSelectionChanged", []uint{uint(8)}).Return(nil)
+
+	selectableRowView.HandleAction(Action{ActionType: ActionSelectRange})
+
+	mocks.viewPos.On("ActiveRowIndex").Return(uint(6)).Once()
+	mocks.child.On("isSelectableRow", uint(6)).Return(true)
+	mocks.child.On("isSelectableRow", uint(7)).Return(true)
+	mocks.child.On("onSelectionChanged", []uint{uint(6), uint(7), uint(8)}).Return(nil)
+
+	selectableRowView.HandleAction(Action{ActionType: ActionSelectRange})
+
+	assert.Equal(t, []uint{6, 7, 8}, selectableRowView.SelectedRows(), "Moving the active row above the anchor should extend the range upwards instead")
+}
+
+func TestActionSelectRangeSkipsNonSelectableRowsButKeepsTheRangeEndpoints(t *testing.T) {
+	selectableRowView, mocks := setupSelectableRowView()
+	mocks.viewPos.On("ActiveRowIndex").Return(uint(1)).Once()
+	mocks.child.On("isSelectableRow", uint(1)).Return(true)
+	mocks.child.On("onSelectionChanged", []uint{uint(1)}).Return(nil)
+
+	selectableRowView.HandleAction(Action{ActionType: ActionSelectRange})
+
+	mocks.viewPos.On("ActiveRowIndex").Return(uint(4)).Once()
+	mocks.child.On("isSelectableRow", uint(2)).Return(false)
+	mocks.child.On("isSelectableRow", uint(3)).Return(false)
+	mocks.child.On("isSelectableRow", uint(4)).Return(true)
+	mocks.child.On("onSelectionChanged", []uint{uint(1), uint(4)}).Return(nil)
+
+	selectableRowView.HandleAction(Action{ActionType: ActionSelectRange})
+
+	assert.Equal(t, []uint{1, 4}, selectableRowView.SelectedRows(), "Non-selectable rows inside the range should be skipped, but the endpoints kept")
+}
+
+func TestActionClearSelectionDiscardsTheSelectionAndAnyInProgressRange(t *testing.T) {
+	selectableRowView, mocks := setupSelectableRowView()
+	mocks.viewPos.On("ActiveRowIndex").Return(uint(2)).Times(2)
+	mocks.viewPos.On("ActiveRowIndex").Return(uint(9))
+	mocks.child.On("isSelectableRow", uint(2)).Return(true)
+	mocks.child.On("isSelectableRow", uint(9)).Return(true)
+	mocks.child.On("onSelectionChanged", mock.Anything).Return(nil)
+
+	selectableRowView.HandleAction(Action{ActionType: ActionSelectRange})
+	handled, err := selectableRowView.HandleAction(Action{ActionType: ActionClearSelection})
+
+	assert.True(t, handled, "ActionClearSelection should be handled")
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{}, selectableRowView.SelectedRows())
+
+	selectableRowView.HandleAction(Action{ActionType: ActionSelectRange})
+
+	assert.Equal(t, []uint{9}, selectableRowView.SelectedRows(), "Clearing the selection should also reset the range anchor, not just the selected rows")
+}
+
+func TestActionSelectAllSelectsEverySelectableRow(t *testing.T) {
+	viewPos := &MockViewPos{}
+	child := &MockSelectableRowChildWindowView{}
+	channels := &MockChannels{}
+	config := &MockConfig{}
+
+	child.On("rows").Return(uint(5))
+	child.On("viewPos").Return(viewPos)
+	viewPos.On("ActiveRowIndex").Return(uint(0))
+	channels.On("UpdateDisplay").Return()
+
+	for rowIndex := uint(0); rowIndex < 5; rowIndex++ {
+		child.On("isSelectableRow", rowIndex).Return(rowIndex%2 == 0)
+	}
+	child.On("onSelectionChanged", []uint{uint(0), uint(2), uint(4)}).Return(nil)
+
+	selectableRowView := NewSelectableRowView(child, channels, config, "test line")
+
+	handled, err := selectableRowView.HandleAction(Action{ActionType: ActionSelectAll})
+
+	assert.True(t, handled, "ActionSelectAll should be handled")
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{0, 2, 4}, selectableRowView.SelectedRows())
+}
+
+func TestSelectionPersistsAcrossScroll(t *testing.T) {
+	selectableRowView, mocks := setupSelectableRowView()
+	mocks.viewPos.On("ActiveRowIndex").Return(uint(5)).Times(3)
+	mocks.viewPos.On("ActiveRowIndex").Return(uint(6))
+	mocks.child.On("isSelectableRow", uint(5)).Return(true)
+	mocks.child.On("onSelectionChanged", []uint{uint(5)}).Return(nil)
+
+	selectableRowView.HandleAction(Action{ActionType: ActionToggleSelection})
+	assert.Equal(t, []uint{5}, selectableRowView.SelectedRows())
+
+	mocks.viewPos.On("MoveLineDown", uint(100)).Return(true)
+	mocks.child.On("isSelectableRow", uint(6)).Return(true)
+	mocks.child.On("onRowSelected", uint(6)).Return(nil)
+
+	selectableRowView.HandleAction(Action{ActionType: ActionNextLine})
+
+	assert.Equal(t, []uint{5}, selectableRowView.SelectedRows(), "Scrolling the active row should not clear an existing multi-selection")
+}